@@ -9,14 +9,24 @@ import (
 	"net/http"
 )
 
-func CreateApi(log *gologger.Logger, haConfig *haproxy.Config, haRuntime *haproxy.Runtime, SSEBroker *metrics.SSEBroker, version string) (*gin.Engine, error) {
+func CreateApi(log *gologger.Logger, haConfig *haproxy.Config, haRuntime haproxy.RuntimeProvider, SSEBroker *metrics.SSEBroker, producers *metrics.ProducerRegistry, streamer *metrics.Streamer, version string) (*gin.Engine, error) {
 
 	gin.SetMode("release")
 
+	requests := metrics.NewRequestHistogram()
+	wsStream := metrics.NewWSStream(SSEBroker)
+	exporter := &metrics.PrometheusExporter{Runtime: haRuntime, Config: haConfig, SSEBroker: SSEBroker, Requests: requests}
+
 	r := gin.New()
 	r.Use(HaproxyMiddleware(haConfig, haRuntime))
 	r.Use(LoggerMiddleware(log))
+	r.Use(RequestMetricsMiddleware(requests))
+	r.Use(ConfigEventsMiddleware(haConfig, SSEBroker))
 	r.Use(gin.Recovery())
+
+	// Prometheus scrapes /metrics by default, so it lives outside of /v1
+	r.GET("/metrics", PrometheusMiddleware(exporter), GetPrometheusMetrics)
+
 	v1 := r.Group("/v1")
 
 	{
@@ -44,6 +54,8 @@ func CreateApi(log *gologger.Logger, haConfig *haproxy.Config, haRuntime *haprox
 		v1.PUT("/backends/:name/servers/:server", PutServerWeight)
 		v1.POST("/backends/:name/servers", PostServer)
 		v1.DELETE("/backends/:name/servers/:server", DeleteServer)
+		v1.GET("/backends/:name/servers/:server/state", GetServerState)
+		v1.PUT("/backends/:name/servers/:server/state", PutServerState)
 
 		/*
 		   Stats
@@ -55,6 +67,22 @@ func CreateApi(log *gologger.Logger, haConfig *haproxy.Config, haRuntime *haprox
 		v1.GET("/stats/stream", SSEMiddleware(SSEBroker), GetSSEStream)
 		v1.HEAD("/stats/stream", GetSSEContentType)
 
+		// The same events as the SSE stream, for clients that need a WebSocket.
+		v1.GET("/stream/ws", WSMiddleware(wsStream), GetWSStream)
+
+		/*
+		   Producers
+		*/
+		v1.GET("/producers", ProducersMiddleware(producers), GetProducers)
+		v1.GET("/producers/:name", ProducersMiddleware(producers), GetProducer)
+		v1.PUT("/producers/:name", ProducersMiddleware(producers), PutProducer)
+
+		/*
+		   Metrics config
+		*/
+		v1.GET("/metrics/config", StreamerMiddleware(streamer), GetMetricsConfig)
+		v1.PUT("/metrics/config", StreamerMiddleware(streamer), PutMetricsConfig)
+
 		/*
 		   Config
 		*/
@@ -89,6 +117,22 @@ func CreateApi(log *gologger.Logger, haConfig *haproxy.Config, haRuntime *haprox
 		v1.PUT("/routes/:route/services/:service/servers/:server", PutServiceServer)
 		v1.POST("/routes/:route/services/:service/servers", PostServiceServer)
 		v1.DELETE("/routes/:route/services/:service/servers/:server", DeleteServiceServer)
+
+		// Drain, put in maintenance or bring back servers without reloading Haproxy.
+		v1.GET("/routes/:route/services/:service/servers/:server/state", GetServiceServerState)
+		v1.PUT("/routes/:route/services/:service/servers/:server/state", PutServiceServerState)
+
+		// Stats of a route, its services and servers, mapped back from the Haproxy proxies.
+		v1.GET("/routes/:route/stats", GetRouteStats)
+		v1.GET("/routes/:route/services/:service/stats", GetRouteServiceStats)
+		v1.GET("/routes/:route/services/:service/servers/:server/stats", GetServiceServerStats)
+
+		// Health of a route: 200 when up, 207 when degraded and 503 when down.
+		v1.GET("/routes/:route/health", GetRouteHealth)
+
+		// Progress of services and servers deleted with ?drain=true
+		v1.GET("/drains", GetDrains)
+
 		/*
 		   Info
 		*/
@@ -108,7 +152,7 @@ func CreateApi(log *gologger.Logger, haConfig *haproxy.Config, haRuntime *haprox
 // config object.
 func HandleReload(c *gin.Context, config *haproxy.Config, status int, message gin.H) {
 
-	runtime := c.MustGet("haRuntime").(*haproxy.Runtime)
+	runtime := c.MustGet("haRuntime").(haproxy.RuntimeProvider)
 
 	err := config.RenderAndPersist()
 	if err != nil {
@@ -125,6 +169,62 @@ func HandleReload(c *gin.Context, config *haproxy.Config, status int, message gi
 	HandleSucces(c, status, message)
 }
 
+// Handles the persisting of the Haproxy config after a mutation that was already applied to the running
+// Haproxy, so no reload is needed.
+func HandlePersist(c *gin.Context, config *haproxy.Config, status int, message gin.H) {
+
+	err := config.RenderAndPersist()
+	if err != nil {
+		HandleError(c, &haproxy.Error{http.StatusInternalServerError, errors.New("Error rendering config file")})
+		return
+	}
+
+	HandleSucces(c, status, message)
+}
+
+// Handles the return of a server state after it was changed on the running Haproxy. The config is only
+// persisted, not reloaded, so the admin state survives the next reload. The name is the one returned to the
+// client, the server is the one Haproxy knows, which differs for servers filling a slot.
+func HandleServerState(c *gin.Context, config *haproxy.Config, backend string, server string, name string, state string) {
+
+	err := config.RenderAndPersist()
+	if err != nil {
+		HandleError(c, &haproxy.Error{http.StatusInternalServerError, errors.New("Error rendering config file")})
+		return
+	}
+
+	status, stateErr := Runtime(c).GetServerStatus(backend, server)
+	if stateErr != nil {
+		HandleError(c, stateErr)
+		return
+	}
+
+	c.JSON(http.StatusOK, haproxy.ServerState{Name: name, State: state, Status: status})
+}
+
+// Sets the admin state of a server on the running Haproxy. Returns false if the state could not be set,
+// in which case the error is already written to the response.
+func setRuntimeServerState(c *gin.Context, backend string, server string, state string) bool {
+
+	if _, err := Runtime(c).SetServerState(backend, server, state); err != nil {
+		HandleError(c, RuntimeError(err))
+		return false
+	}
+	return true
+}
+
+// Maps an error of a command on the Haproxy socket to the matching status code
+func RuntimeError(err error) *haproxy.Error {
+
+	switch err {
+	case haproxy.ErrNoSuchServer, haproxy.ErrNoSuchBackend:
+		return &haproxy.Error{http.StatusNotFound, err}
+	case haproxy.ErrPermissionDenied:
+		return &haproxy.Error{http.StatusForbidden, err}
+	}
+	return &haproxy.Error{http.StatusInternalServerError, err}
+}
+
 // Handles the simple successful return status
 func HandleSucces(c *gin.Context, status int, message gin.H) {
 	if status == 204 {
@@ -145,6 +245,6 @@ func Config(c *gin.Context) *haproxy.Config {
 }
 
 // helper methods to grab the injected Runtime from the Http context
-func Runtime(c *gin.Context) *haproxy.Runtime {
-	return c.MustGet("haRuntime").(*haproxy.Runtime)
+func Runtime(c *gin.Context) haproxy.RuntimeProvider {
+	return c.MustGet("haRuntime").(haproxy.RuntimeProvider)
 }
@@ -1,11 +1,20 @@
 package api
 
 import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 	"github.com/magneticio/vamp-router/haproxy"
 	"github.com/magneticio/vamp-router/helpers"
 	"github.com/magneticio/vamp-router/metrics"
 	gologger "github.com/op/go-logging"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -23,19 +32,292 @@ func TestApi_CreateAPI(t *testing.T) {
 
 	sseChannel := make(chan metrics.Metric)
 
-	sseBroker := &metrics.SSEBroker{
-		make(map[chan metrics.Metric]bool),
-		make(chan (chan metrics.Metric)),
-		make(chan (chan metrics.Metric)),
-		sseChannel,
-		log,
-	}
+	sseBroker := metrics.NewSSEBroker(sseChannel, log)
+	producers := metrics.NewProducerRegistry(make(chan metrics.Metric), log)
 
 	haConfig := haproxy.Config{TemplateFile: TEMPLATE_FILE, ConfigFile: CONFIG_FILE, JsonFile: JSON_FILE, PidFile: PID_FILE}
 	haRuntime := haproxy.Runtime{Binary: helpers.HaproxyLocation()}
+	streamer := metrics.NewStreamer(&haRuntime, 3000, log)
 
-	if _, err := CreateApi(log, &haConfig, &haRuntime, sseBroker, "v.test"); err != nil {
+	if _, err := CreateApi(log, &haConfig, &haRuntime, sseBroker, producers, streamer, "v.test"); err != nil {
 		t.Errorf("Failed to create API")
 	}
 
 }
+
+/*
+  The API backed by a fake Haproxy runtime, with one route "api_route" and its service "service_a" of two
+  servers. The returned func cleans up.
+*/
+func newTestApi(t *testing.T, name string) (*gin.Engine, *haproxy.FakeRuntime, *metrics.SSEBroker, func()) {
+
+	log := gologger.MustGetLogger("vamp-router")
+
+	haConfig := &haproxy.Config{
+		WorkingDir:   "/tmp",
+		TemplateFile: TEMPLATE_FILE,
+		ConfigFile:   "/tmp/" + name + ".cfg",
+		JsonFile:     "/tmp/" + name + ".json",
+	}
+	haConfig.InitializeConfig()
+
+	route := haproxy.Route{
+		Name:     "api_route",
+		Port:     9050,
+		Protocol: "http",
+		Services: []*haproxy.Service{
+			&haproxy.Service{Name: "service_a", Weight: 100, Servers: []*haproxy.Server{
+				&haproxy.Server{Name: "server_a", Host: "192.168.2.2", Port: 8081},
+				&haproxy.Server{Name: "server_b", Host: "192.168.2.2", Port: 8082},
+			}},
+		},
+	}
+	if err := haConfig.AddRoute(route); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	haRuntime, err := haproxy.NewFakeRuntime("/tmp/" + name + ".sock")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	haRuntime.Reload(haConfig)
+
+	sseBroker := metrics.NewSSEBroker(make(chan metrics.Metric), log)
+	go sseBroker.Start()
+
+	producers := metrics.NewProducerRegistry(make(chan metrics.Metric), log)
+	producers.Configure(metrics.ProducerSettings{})
+
+	streamer := metrics.NewStreamer(haRuntime, 3000, log)
+
+	api, err := CreateApi(log, haConfig, haRuntime, sseBroker, producers, streamer, "v.test")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return api, haRuntime, sseBroker, func() {
+		haRuntime.Close()
+		os.Remove(haConfig.ConfigFile)
+		os.Remove(haConfig.JsonFile)
+	}
+}
+
+// performs a request on the API and decodes the JSON response into result, when it is not nil
+func request(t *testing.T, api http.Handler, method string, path string, body string, result interface{}) *httptest.ResponseRecorder {
+
+	var reader io.Reader
+	if len(body) > 0 {
+		reader = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, path, reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	if result != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), result); err != nil {
+			t.Fatalf("Failed to decode the response of %s %s: %s", method, path, w.Body.String())
+		}
+	}
+	return w
+}
+
+func TestApi_ServerState(t *testing.T) {
+
+	api, _, _, cleanup := newTestApi(t, "vamp_api_state_test")
+	defer cleanup()
+
+	path := "/v1/routes/api_route/services/service_a/servers/server_a/state"
+
+	var state haproxy.ServerState
+	if w := request(t, api, "GET", path, "", &state); w.Code != 200 || state.Status != "UP" {
+		t.Errorf("Expected a running server, got %d %+v", w.Code, state)
+	}
+
+	if w := request(t, api, "PUT", path, `{"state": "drain"}`, &state); w.Code != 200 || state.State != "drain" || state.Status != "DRAIN" {
+		t.Errorf("Failed to drain the server, got %d %+v", w.Code, state)
+	}
+
+	if w := request(t, api, "PUT", path, `{"state": "sleeping"}`, nil); w.Code != 400 {
+		t.Errorf("Expected an unknown state to be refused, got %d", w.Code)
+	}
+
+	if w := request(t, api, "GET", "/v1/routes/api_route/services/service_a/servers/server_x/state", "", nil); w.Code != 404 {
+		t.Errorf("Expected a 404 for a non-existent server, got %d", w.Code)
+	}
+}
+
+func TestApi_Drains(t *testing.T) {
+
+	api, haRuntime, _, cleanup := newTestApi(t, "vamp_api_drain_test")
+	defer cleanup()
+
+	var drain haproxy.Drain
+	if w := request(t, api, "DELETE", "/v1/routes/api_route/services/service_a/servers/server_b?drain=true&timeout=5s", "", &drain); w.Code != 202 || drain.Status != haproxy.DRAINING {
+		t.Fatalf("Failed to start draining the server, got %d %+v", w.Code, drain)
+	}
+
+	if w := request(t, api, "DELETE", "/v1/routes/api_route/services/service_a/servers/server_b?drain=true&timeout=never", "", nil); w.Code != 400 {
+		t.Errorf("Expected an invalid timeout to be refused, got %d", w.Code)
+	}
+
+	// the fake reports no sessions, so the server is removed right away
+	var drains []haproxy.Drain
+	for i := 0; i < 100; i++ {
+		request(t, api, "GET", "/v1/drains", "", &drains)
+		if len(drains) == 1 && drains[0].Status != haproxy.DRAINING {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if len(drains) != 1 || drains[0].Server != "server_b" || drains[0].Status != haproxy.REMOVED || haRuntime.Reloads != 2 {
+		t.Errorf("Failed to remove the drained server, got %+v", drains)
+	}
+}
+
+func TestApi_RouteStats(t *testing.T) {
+
+	api, haRuntime, _, cleanup := newTestApi(t, "vamp_api_stats_test")
+	defer cleanup()
+
+	haRuntime.Socket.SetCounter(haproxy.BackendName("api_route", "service_a"), "server_a", "scur", 3)
+
+	// durations are only marshalled, so decode just the fields checked here
+	var stats struct {
+		Route    string
+		Services []struct {
+			Service string
+			Weight  int
+			Servers []struct {
+				Server string
+			}
+		}
+	}
+	if w := request(t, api, "GET", "/v1/routes/api_route/stats", "", &stats); w.Code != 200 || stats.Route != "api_route" || len(stats.Services) != 1 {
+		t.Fatalf("Failed to get the stats of the route, got %d %+v", w.Code, stats)
+	}
+
+	if service := stats.Services[0]; service.Service != "service_a" || service.Weight != 100 || len(service.Servers) != 2 {
+		t.Errorf("Failed to map the stats of the service, got %+v", service)
+	}
+
+	if w := request(t, api, "GET", "/v1/routes/no_such_route/stats", "", nil); w.Code != 404 {
+		t.Errorf("Expected a 404 for a non-existent route, got %d", w.Code)
+	}
+}
+
+func TestApi_RouteHealth(t *testing.T) {
+
+	api, _, _, cleanup := newTestApi(t, "vamp_api_health_test")
+	defer cleanup()
+
+	var health struct {
+		Status string
+	}
+	if w := request(t, api, "GET", "/v1/routes/api_route/health", "", &health); w.Code != 200 || health.Status != haproxy.HEALTH_UP {
+		t.Errorf("Expected a healthy route, got %d %+v", w.Code, health)
+	}
+
+	for _, server := range []string{"server_a", "server_b"} {
+		request(t, api, "PUT", "/v1/routes/api_route/services/service_a/servers/"+server+"/state", `{"state": "maint"}`, nil)
+	}
+
+	if w := request(t, api, "GET", "/v1/routes/api_route/health", "", &health); w.Code != 503 || health.Status != haproxy.HEALTH_DOWN {
+		t.Errorf("Expected a route without servers to be down, got %d %+v", w.Code, health)
+	}
+}
+
+func TestApi_PrometheusMetrics(t *testing.T) {
+
+	api, haRuntime, _, cleanup := newTestApi(t, "vamp_api_prometheus_test")
+	defer cleanup()
+
+	haRuntime.Socket.SetCounter(haproxy.BackendName("api_route", "service_a"), "server_a", "scur", 3)
+
+	w := request(t, api, "GET", "/metrics", "", nil)
+	if w.Code != 200 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("Failed to get the Prometheus metrics, got %d", w.Code)
+	}
+
+	expected := `haproxy_current_sessions{route="api_route",service="service_a",server="server_a",kind="server",proxy="server"} 3`
+	if !strings.Contains(w.Body.String(), expected) {
+		t.Errorf("Expected %s in the metrics, got %s", expected, w.Body.String())
+	}
+}
+
+func TestApi_Producers(t *testing.T) {
+
+	api, _, _, cleanup := newTestApi(t, "vamp_api_producers_test")
+	defer cleanup()
+
+	var producers []metrics.ProducerStatus
+	if w := request(t, api, "GET", "/v1/producers", "", &producers); w.Code != 200 || len(producers) == 0 {
+		t.Fatalf("Failed to list the producers, got %d %+v", w.Code, producers)
+	}
+
+	// producers without a host can not be enabled
+	if w := request(t, api, "PUT", "/v1/producers/kafka", `{"enabled": true}`, nil); w.Code != 400 {
+		t.Errorf("Expected enabling a producer without brokers to be refused, got %d", w.Code)
+	}
+
+	if w := request(t, api, "GET", "/v1/producers/no_such_producer", "", nil); w.Code != 404 {
+		t.Errorf("Expected a 404 for a non-existent producer, got %d", w.Code)
+	}
+}
+
+func TestApi_MetricsConfig(t *testing.T) {
+
+	api, _, _, cleanup := newTestApi(t, "vamp_api_metrics_config_test")
+	defer cleanup()
+
+	var config metrics.StreamerConfig
+	if w := request(t, api, "GET", "/v1/metrics/config", "", &config); w.Code != 200 || config.PollInterval != 3000 {
+		t.Errorf("Expected the current metrics config, got %d %+v", w.Code, config)
+	}
+
+	if w := request(t, api, "PUT", "/v1/metrics/config", `{"pollInterval": 1000}`, &config); w.Code != 200 || config.PollInterval != 1000 || len(config.Metrics) == 0 {
+		t.Errorf("Failed to change only the poll interval, got %d %+v", w.Code, config)
+	}
+
+	if w := request(t, api, "PUT", "/v1/metrics/config", `{"pollInterval": 1}`, nil); w.Code != 400 {
+		t.Errorf("Expected a too short poll interval to be refused, got %d", w.Code)
+	}
+}
+
+func TestApi_WSStream(t *testing.T) {
+
+	api, _, sseBroker, cleanup := newTestApi(t, "vamp_api_ws_test")
+	defer cleanup()
+
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/v1/stream/ws", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer conn.Close()
+
+	for i := 0; i < 100 && sseBroker.ClientCount() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a change of the config is published on the stream
+	request(t, api, "PUT", "/v1/routes/api_route/services/service_a/servers/server_a/state", `{"state": "drain"}`, nil)
+
+	var event metrics.WSEvent
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if event.Event != "router-config" || event.Data == nil || event.Data.Value != 1 {
+		t.Errorf("Expected a config event with the new revision, got %+v", event)
+	}
+}
@@ -125,25 +125,72 @@ func PutServerWeight(c *gin.Context) {
 	server := c.Params.ByName("server")
 
 	if c.Bind(&json) {
-		status, err := Runtime(c).SetWeight(backend, server, json.Weight)
-
 		// check on Runtime errors
-		if err != nil {
-			c.String(500, err.Error())
+		if _, err := Runtime(c).SetWeight(backend, server, json.Weight); err != nil {
+			HandleError(c, RuntimeError(err))
 		} else {
-			switch status {
-			case "No such server.\n\n":
-				c.String(404, status)
-			case "No such backend.\n\n":
-				c.String(404, status)
-			default:
-
-				//update the Config(c) object with the new weight
-				if err := Config(c).SetWeight(backend, server, json.Weight); err != nil {
-					HandleError(c, err)
-				} else {
-					HandleReload(c, Config(c), http.StatusOK, gin.H{"status": "updated server weight"})
-				}
+
+			//update the Config(c) object with the new weight
+			if err := Config(c).SetWeight(backend, server, json.Weight); err != nil {
+				HandleError(c, err)
+			} else {
+				HandleReload(c, Config(c), http.StatusOK, gin.H{"status": "updated server weight"})
+			}
+		}
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "bad request"})
+	}
+}
+
+func GetServerState(c *gin.Context) {
+
+	Config(c).BeginReadTrans()
+	defer Config(c).EndReadTrans()
+
+	backend := c.Params.ByName("name")
+	server := c.Params.ByName("server")
+
+	result, err := Config(c).GetServer(backend, server)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+
+	if status, err := Runtime(c).GetServerStatus(backend, server); err != nil {
+		HandleError(c, err)
+	} else {
+		c.JSON(http.StatusOK, haproxy.ServerState{Name: server, State: result.State, Status: status})
+	}
+}
+
+func PutServerState(c *gin.Context) {
+
+	Config(c).BeginWriteTrans()
+	defer Config(c).EndWriteTrans()
+
+	var json UpdateState
+	backend := c.Params.ByName("name")
+	server := c.Params.ByName("server")
+
+	if c.Bind(&json) {
+
+		if _, err := haproxy.Validate(json); err != nil {
+			HandleError(c, &haproxy.Error{http.StatusBadRequest, err})
+			return
+		}
+
+		if _, err := Config(c).GetServer(backend, server); err != nil {
+			HandleError(c, err)
+			return
+		}
+
+		if setRuntimeServerState(c, backend, server, json.State) {
+
+			//update the Config(c) object with the new state
+			if err := Config(c).SetServerState(backend, server, json.State); err != nil {
+				HandleError(c, err)
+			} else {
+				HandleServerState(c, Config(c), backend, server, server, json.State)
 			}
 		}
 	} else {
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/magneticio/vamp-router/haproxy"
+	"net/http"
+	"time"
+)
+
+const (
+	DRAIN_TIMEOUT = 60 * time.Second
+)
+
+func GetDrains(c *gin.Context) {
+
+	Config(c).BeginReadTrans()
+	defer Config(c).EndReadTrans()
+
+	c.JSON(http.StatusOK, Config(c).GetDrains())
+}
+
+// checks if a delete request asks for a graceful drain, i.e. ?drain=true&timeout=30s
+func drainRequested(c *gin.Context) bool {
+	return c.Request.URL.Query().Get("drain") == "true"
+}
+
+// gets the drain timeout from the request, falling back to the default timeout
+func drainTimeout(c *gin.Context) (time.Duration, *haproxy.Error) {
+
+	param := c.Request.URL.Query().Get("timeout")
+	if len(param) == 0 {
+		return DRAIN_TIMEOUT, nil
+	}
+
+	timeout, err := time.ParseDuration(param)
+	if err != nil || timeout <= 0 {
+		return 0, &haproxy.Error{http.StatusBadRequest, errors.New("invalid drain timeout: " + param)}
+	}
+	return timeout, nil
+}
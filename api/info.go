@@ -2,6 +2,7 @@ package api
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/magneticio/vamp-router/haproxy"
 	"net/http"
 )
 
@@ -9,16 +10,34 @@ func GetInfo(c *gin.Context) {
 
 	version := c.MustGet("appVersion").(string)
 
-	status, err := Runtime(c).GetInfo()
+	Config(c).BeginReadTrans()
+	defer Config(c).EndReadTrans()
+
+	// the process status is most useful when Haproxy is down, so it is returned on errors as well
+	runtimeStatus := Runtime(c).Status(Config(c))
+
+	// the old format with all values as strings is still available
+	var status interface{}
+	var err *haproxy.Error
+	if legacyRequested(c) {
+		status, err = Runtime(c).GetInfo()
+	} else {
+		status, err = Runtime(c).GetProcessInfo()
+	}
+
 	if err != nil {
-		HandleError(c, err)
+		c.JSON(err.Code, gin.H{"status": err.Error(), "process": runtimeStatus.Process})
 	} else {
 
 		apiInfo := struct {
-			Message string
-			Version string
-			Status  interface{}
-		}{"Hi, I'm Vamp Router! How are you?", version, status}
+			Message     string
+			Version     string
+			Status      interface{}
+			ServerState interface{}
+			Reload      interface{}
+			Process     interface{}
+			Workers     interface{}
+		}{"Hi, I'm Vamp Router! How are you?", version, status, runtimeStatus.ServerState, runtimeStatus.Reload, runtimeStatus.Process, runtimeStatus.Workers}
 
 		c.JSON(http.StatusOK, apiInfo)
 	}
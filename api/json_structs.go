@@ -3,3 +3,11 @@ package api
 type UpdateWeight struct {
 	Weight int `json:"weight" binding:"required"`
 }
+
+type UpdateState struct {
+	State string `json:"state" binding:"required" valid:"serverState"`
+}
+
+type UpdateProducer struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
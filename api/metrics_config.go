@@ -0,0 +1,32 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/magneticio/vamp-router/haproxy"
+	"github.com/magneticio/vamp-router/metrics"
+	"net/http"
+)
+
+// helper method to grab the injected streamer from the Http context
+func Streamer(c *gin.Context) *metrics.Streamer {
+	return c.MustGet("streamer").(*metrics.Streamer)
+}
+
+func GetMetricsConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, Streamer(c).Config())
+}
+
+// Changes the config of the streamer. Fields left out keep their current value, i.e. {"pollInterval": 1000}
+func PutMetricsConfig(c *gin.Context) {
+
+	config := Streamer(c).Config()
+
+	if c.Bind(&config) {
+
+		if err := Streamer(c).SetConfig(config); err != nil {
+			HandleError(c, &haproxy.Error{http.StatusBadRequest, err})
+			return
+		}
+		GetMetricsConfig(c)
+	}
+}
@@ -8,7 +8,7 @@ import (
 	"time"
 )
 
-func HaproxyMiddleware(haConfig *haproxy.Config, haRuntime *haproxy.Runtime) gin.HandlerFunc {
+func HaproxyMiddleware(haConfig *haproxy.Config, haRuntime haproxy.RuntimeProvider) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Set("haConfig", haConfig)
 		c.Set("haRuntime", haRuntime)
@@ -43,6 +43,21 @@ func LoggerMiddleware(log *gologger.Logger) gin.HandlerFunc {
 	}
 }
 
+// records the latency of every request for the Prometheus endpoint
+func RequestMetricsMiddleware(requests *metrics.RequestHistogram) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		requests.Observe(c.Request.Method, c.Writer.Status(), time.Since(start))
+	}
+}
+
+func PrometheusMiddleware(exporter *metrics.PrometheusExporter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("prometheusExporter", exporter)
+	}
+}
+
 func SSEMiddleware(SSEBroker *metrics.SSEBroker) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
@@ -54,6 +69,48 @@ func SSEMiddleware(SSEBroker *metrics.SSEBroker) gin.HandlerFunc {
 	}
 }
 
+func WSMiddleware(WSStream *metrics.WSStream) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("wsStream", WSStream)
+	}
+}
+
+// publishes a config event on the streams when a request changed the config
+func ConfigEventsMiddleware(haConfig *haproxy.Config, SSEBroker *metrics.SSEBroker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		if c.Request.Method == "GET" || c.Request.Method == "HEAD" {
+			return
+		}
+
+		haConfig.BeginReadTrans()
+		revision := haConfig.Revision
+		haConfig.EndReadTrans()
+
+		c.Next()
+
+		haConfig.BeginReadTrans()
+		changed := haConfig.Revision
+		haConfig.EndReadTrans()
+
+		if changed != revision {
+			SSEBroker.Publish(metrics.ConfigEvent(changed))
+		}
+	}
+}
+
+func StreamerMiddleware(streamer *metrics.Streamer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("streamer", streamer)
+	}
+}
+
+func ProducersMiddleware(producers *metrics.ProducerRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("producers", producers)
+	}
+}
+
 func InfoMiddleWare(version string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Set("appVersion", version)
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/magneticio/vamp-router/haproxy"
+	"github.com/magneticio/vamp-router/metrics"
+	"net/http"
+)
+
+// helper method to grab the injected producer registry from the Http context
+func Producers(c *gin.Context) *metrics.ProducerRegistry {
+	return c.MustGet("producers").(*metrics.ProducerRegistry)
+}
+
+func GetProducers(c *gin.Context) {
+	c.JSON(http.StatusOK, Producers(c).Status())
+}
+
+func GetProducer(c *gin.Context) {
+
+	status, err := Producers(c).Get(c.Params.ByName("name"))
+	if err != nil {
+		HandleError(c, ProducerError(err))
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// enables or disables a producer, i.e. {"enabled": false}
+func PutProducer(c *gin.Context) {
+
+	var json UpdateProducer
+	name := c.Params.ByName("name")
+
+	if c.Bind(&json) {
+
+		var err error
+		if *json.Enabled {
+			err = Producers(c).Enable(name)
+		} else {
+			err = Producers(c).Disable(name)
+		}
+
+		if err != nil {
+			HandleError(c, ProducerError(err))
+			return
+		}
+		GetProducer(c)
+	}
+}
+
+// Maps an error of the producer registry to the matching status code. Producers that fail to start,
+// i.e. because no host is configured, are a bad request.
+func ProducerError(err error) *haproxy.Error {
+
+	if err == metrics.ErrNoSuchProducer {
+		return &haproxy.Error{http.StatusNotFound, err}
+	}
+	return &haproxy.Error{http.StatusBadRequest, err}
+}
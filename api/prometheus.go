@@ -0,0 +1,23 @@
+package api
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"github.com/magneticio/vamp-router/metrics"
+	"net/http"
+)
+
+func GetPrometheusMetrics(c *gin.Context) {
+
+	exporter := c.MustGet("prometheusExporter").(*metrics.PrometheusExporter)
+
+	Config(c).BeginReadTrans()
+	defer Config(c).EndReadTrans()
+
+	var buf bytes.Buffer
+	if err := exporter.Write(&buf); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4", buf.Bytes())
+}
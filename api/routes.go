@@ -183,6 +183,19 @@ func DeleteRouteService(c *gin.Context) {
 	routeName := c.Params.ByName("route")
 	serviceName := c.Params.ByName("service")
 
+	// remove the service only after its sessions are drained
+	if drainRequested(c) {
+		timeout, err := drainTimeout(c)
+		if err != nil {
+			HandleError(c, err)
+		} else if drain, err := Config(c).DrainRouteService(Runtime(c), routeName, serviceName, timeout); err != nil {
+			HandleError(c, err)
+		} else {
+			c.JSON(http.StatusAccepted, drain)
+		}
+		return
+	}
+
 	if err := Config(c).DeleteRouteService(routeName, serviceName); err != nil {
 		HandleError(c, err)
 	} else {
@@ -232,10 +245,26 @@ func DeleteServiceServer(c *gin.Context) {
 	serviceName := c.Params.ByName("service")
 	serverName := c.Params.ByName("server")
 
-	if err := Config(c).DeleteServiceServer(routeName, serviceName, serverName); err != nil {
+	// remove the server only after its sessions are drained
+	if drainRequested(c) {
+		timeout, err := drainTimeout(c)
+		if err != nil {
+			HandleError(c, err)
+		} else if drain, err := Config(c).DrainServiceServer(Runtime(c), routeName, serviceName, serverName, timeout); err != nil {
+			HandleError(c, err)
+		} else {
+			c.JSON(http.StatusAccepted, drain)
+		}
+		return
+	}
+
+	// servers filling a slot are removed without a reload
+	if reload, err := Config(c).DeleteServiceServerLive(Runtime(c), routeName, serviceName, serverName); err != nil {
 		HandleError(c, err)
-	} else {
+	} else if reload {
 		HandleReload(c, Config(c), http.StatusNoContent, gin.H{})
+	} else {
+		HandlePersist(c, Config(c), http.StatusNoContent, gin.H{})
 	}
 }
 
@@ -249,10 +278,13 @@ func PostServiceServer(c *gin.Context) {
 	serviceName := c.Params.ByName("service")
 
 	if c.Bind(&server) {
-		if err := Config(c).AddServiceServer(routeName, serviceName, &server); err != nil {
+		// servers filling a free slot are added without a reload
+		if reload, err := Config(c).AddServiceServerLive(Runtime(c), routeName, serviceName, &server); err != nil {
 			HandleError(c, err)
-		} else {
+		} else if reload {
 			HandleReload(c, Config(c), http.StatusCreated, gin.H{"status": "created server"})
+		} else {
+			HandlePersist(c, Config(c), http.StatusCreated, gin.H{"status": "created server"})
 		}
 	} else {
 		c.JSON(http.StatusBadRequest, gin.H{"status": "bad request"})
@@ -279,3 +311,132 @@ func PutServiceServer(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"status": "bad request"})
 	}
 }
+
+func GetServiceServerState(c *gin.Context) {
+
+	Config(c).BeginReadTrans()
+	defer Config(c).EndReadTrans()
+
+	routeName := c.Params.ByName("route")
+	serviceName := c.Params.ByName("service")
+	serverName := c.Params.ByName("server")
+
+	result, err := Config(c).GetServiceServer(routeName, serviceName, serverName)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+
+	backendName := haproxy.BackendName(routeName, serviceName)
+	if status, err := Runtime(c).GetServerStatus(backendName, Config(c).BackendServerName(routeName, serviceName, serverName)); err != nil {
+		HandleError(c, err)
+	} else {
+		c.JSON(http.StatusOK, haproxy.ServerState{Name: serverName, State: result.State, Status: status})
+	}
+}
+
+func PutServiceServerState(c *gin.Context) {
+
+	Config(c).BeginWriteTrans()
+	defer Config(c).EndWriteTrans()
+
+	var json UpdateState
+	routeName := c.Params.ByName("route")
+	serviceName := c.Params.ByName("service")
+	serverName := c.Params.ByName("server")
+	backendName := haproxy.BackendName(routeName, serviceName)
+
+	if c.Bind(&json) {
+
+		if _, err := haproxy.Validate(json); err != nil {
+			HandleError(c, &haproxy.Error{http.StatusBadRequest, err})
+			return
+		}
+
+		if _, err := Config(c).GetServiceServer(routeName, serviceName, serverName); err != nil {
+			HandleError(c, err)
+			return
+		}
+
+		// for services with slots, Haproxy knows the server by the name of its slot
+		slotName := Config(c).BackendServerName(routeName, serviceName, serverName)
+
+		if setRuntimeServerState(c, backendName, slotName, json.State) {
+			if err := Config(c).SetServiceServerState(routeName, serviceName, serverName, json.State); err != nil {
+				HandleError(c, err)
+			} else {
+				HandleServerState(c, Config(c), backendName, slotName, serverName, json.State)
+			}
+		}
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "bad request"})
+	}
+}
+
+func GetRouteStats(c *gin.Context) {
+
+	Config(c).BeginReadTrans()
+	defer Config(c).EndReadTrans()
+
+	routeName := c.Params.ByName("route")
+
+	if result, err := Config(c).GetRouteStats(Runtime(c), routeName); err != nil {
+		HandleError(c, err)
+	} else {
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func GetRouteServiceStats(c *gin.Context) {
+
+	Config(c).BeginReadTrans()
+	defer Config(c).EndReadTrans()
+
+	routeName := c.Params.ByName("route")
+	serviceName := c.Params.ByName("service")
+
+	if result, err := Config(c).GetRouteServiceStats(Runtime(c), routeName, serviceName); err != nil {
+		HandleError(c, err)
+	} else {
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func GetServiceServerStats(c *gin.Context) {
+
+	Config(c).BeginReadTrans()
+	defer Config(c).EndReadTrans()
+
+	routeName := c.Params.ByName("route")
+	serviceName := c.Params.ByName("service")
+	serverName := c.Params.ByName("server")
+
+	if result, err := Config(c).GetServiceServerStats(Runtime(c), routeName, serviceName, serverName); err != nil {
+		HandleError(c, err)
+	} else {
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func GetRouteHealth(c *gin.Context) {
+
+	Config(c).BeginReadTrans()
+	defer Config(c).EndReadTrans()
+
+	routeName := c.Params.ByName("route")
+
+	result, err := Config(c).GetRouteHealth(Runtime(c), routeName)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+
+	switch result.Status {
+	case haproxy.HEALTH_UP:
+		c.JSON(http.StatusOK, result)
+	case haproxy.HEALTH_DEGRADED:
+		c.JSON(207, result)
+	default:
+		c.JSON(http.StatusServiceUnavailable, result)
+	}
+}
@@ -7,46 +7,45 @@ import (
 )
 
 func GetAllStats(c *gin.Context) {
-
-	status, err := Runtime(c).GetJsonStats("all")
-	if err != nil {
-		c.String(500, err.Error())
-	} else {
-		c.JSON(http.StatusOK, status)
-	}
-
+	getStats(c, "all")
 }
 
 func GetBackendStats(c *gin.Context) {
-
-	status, err := Runtime(c).GetJsonStats("backend")
-	if err != nil {
-		c.String(500, err.Error())
-	} else {
-		c.JSON(http.StatusOK, status)
-	}
-
+	getStats(c, "backend")
 }
 
 func GetFrontendStats(c *gin.Context) {
-
-	status, err := Runtime(c).GetJsonStats("frontend")
-	if err != nil {
-		c.String(500, err.Error())
-	} else {
-		c.JSON(http.StatusOK, status)
-	}
+	getStats(c, "frontend")
 }
 
 func GetServerStats(c *gin.Context) {
+	getStats(c, "server")
+}
 
-	status, err := Runtime(c).GetJsonStats("server")
+// returns the typed stats, or the stats with all values as strings with ?legacy=true
+func getStats(c *gin.Context, statsType string) {
+
+	if legacyRequested(c) {
+		status, err := Runtime(c).GetJsonStats(statsType)
+		if err != nil {
+			c.String(500, err.Error())
+		} else {
+			c.JSON(http.StatusOK, status)
+		}
+		return
+	}
+
+	status, err := Runtime(c).GetProxyStats(statsType)
 	if err != nil {
 		c.String(500, err.Error())
 	} else {
 		c.JSON(http.StatusOK, status)
 	}
+}
 
+// checks if a request asks for the legacy format with all values as strings, i.e. ?legacy=true
+func legacyRequested(c *gin.Context) bool {
+	return c.Request.URL.Query().Get("legacy") == "true"
 }
 
 func GetSSEStream(c *gin.Context) {
@@ -54,6 +53,11 @@ func GetSSEStream(c *gin.Context) {
 	sseBroker.ServeHTTP(c.Writer, c.Request)
 }
 
+func GetWSStream(c *gin.Context) {
+	wsStream := c.MustGet("wsStream").(*metrics.WSStream)
+	wsStream.ServeHTTP(c.Writer, c.Request)
+}
+
 func GetSSEContentType(c *gin.Context) {
 	c.Writer.Header().Set("X-VAMP-STREAM", "vamp-router")
 	c.String(http.StatusOK, "")
@@ -0,0 +1,79 @@
+--[[
+
+  Traffic mirroring for vamp-router.
+
+  The vamp_mirror action takes a copy of the current HTTP request and puts it on a queue. A background task
+  sends the queued copies to the unix socket of a mirror frontend and discards the responses, so mirroring
+  never adds latency to the live request. When the queue is full, copies are dropped.
+
+  usage:
+
+    http-request lua.vamp_mirror <unix socket> if { rand(100) lt <percentage> }
+
+  Requires Haproxy 1.8 or higher with Lua support.
+
+]]
+
+local queue = {}
+local max_queue = 1000
+
+-- these headers are set again for the copy
+local skip_headers = {
+  ["connection"] = true,
+  ["content-length"] = true,
+  ["transfer-encoding"] = true,
+}
+
+core.register_action("vamp_mirror", { "http-req" }, function(txn, sock)
+
+  if #queue >= max_queue then
+    return
+  end
+
+  local path = txn.sf:path()
+  local query = txn.sf:query()
+  if query ~= nil and query ~= "" then
+    path = path .. "?" .. query
+  end
+
+  local request = { txn.sf:method() .. " " .. path .. " HTTP/1.1" }
+
+  -- header values are indexed from 0
+  for name, values in pairs(txn.http:req_get_headers()) do
+    if not skip_headers[name] then
+      for _, value in pairs(values) do
+        table.insert(request, name .. ": " .. value)
+      end
+    end
+  end
+
+  local body = txn.sf:req_body() or ""
+
+  table.insert(request, "x-vamp-mirror: true")
+  table.insert(request, "connection: close")
+  table.insert(request, "content-length: " .. string.len(body))
+
+  table.insert(queue, { sock = sock, payload = table.concat(request, "\r\n") .. "\r\n\r\n" .. body })
+
+end, 1)
+
+core.register_task(function()
+
+  while true do
+    local item = table.remove(queue, 1)
+
+    if item == nil then
+      core.msleep(10)
+    else
+      local socket = core.tcp()
+      socket:settimeout(5)
+
+      -- only read the status line, the rest of the response is discarded
+      if socket:connect("unix@" .. item.sock) then
+        socket:send(item.payload)
+        socket:receive("*l")
+      end
+      socket:close()
+    end
+  end
+end)
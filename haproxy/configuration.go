@@ -31,6 +31,7 @@ func (c *Config) InitializeConfig() {
 	c.Frontends = []*Frontend{}
 	c.Backends = []*Backend{}
 	c.Routes = []Route{}
+	c.Drains = []*Drain{}
 	c.Mutex = new(sync.RWMutex)
 }
 
@@ -51,6 +52,52 @@ func (c *Config) SetWeight(backend string, server string, weight int) *Error {
 	return &Error{404, errors.New("no server found")}
 }
 
+/*
+  Updates the admin state of a server of a specific backend, so it survives a reload. When the backend is the
+  backend of a route service, the server of the service is updated too, so updating the route keeps the state.
+*/
+func (c *Config) SetServerState(backend string, server string, state string) *Error {
+
+	if _, err := Validate(ServerState{Name: server, State: state}); err != nil {
+		return &Error{400, err}
+	}
+
+	for _, be := range c.Backends {
+		if be.Name == backend {
+			for _, srv := range be.Servers {
+				if srv.Name == server {
+					srv.State = state
+
+					// the server of the service filling a slot has a name of its own
+					if srv.Slot {
+						server = srv.SlotServer
+					}
+					c.setRouteServerState(backend, server, state)
+					return nil
+				}
+			}
+		}
+	}
+
+	return &Error{404, errors.New("no server found")}
+}
+
+// updates the admin state of the server of a route service behind a backend server, if there is one
+func (c *Config) setRouteServerState(backend string, server string, state string) {
+
+	for _, rt := range c.Routes {
+		for _, svc := range rt.Services {
+			if BackendName(rt.Name, svc.Name) == backend {
+				for _, srv := range svc.Servers {
+					if srv.Name == server {
+						srv.State = state
+					}
+				}
+			}
+		}
+	}
+}
+
 // the transactions methods are kept separate so we can chain an arbitrary set of operations
 // on the Config object within one transaction. Alas, this burdons the developer with extra housekeeping
 // but gives you more control over the flow of mutations and reads without risking deadlocks or duplicating
@@ -118,6 +165,10 @@ func (c *Config) GetFrontend(name string) (*Frontend, *Error) {
 // adds a frontend
 func (c *Config) AddFrontend(frontend *Frontend) *Error {
 
+	if _, err := Validate(frontend); err != nil {
+		return &Error{400, err}
+	}
+
 	if c.FrontendExists(frontend.Name) {
 		return nil
 	}
@@ -158,6 +209,7 @@ func (c *Config) AddFilter(frontend string, filter *Filter) error {
 	for _, fe := range c.Frontends {
 		if fe.Name == frontend {
 			fe.Filters = append(fe.Filters, filter)
+			c.addFilterFailover(fe, filter)
 		}
 	}
 	return nil
@@ -171,6 +223,7 @@ func (c *Config) DeleteFilter(frontendName string, filterName string) *Error {
 			for i, filter := range fe.Filters {
 				if filter.Name == filterName {
 					fe.Filters = append(fe.Filters[:i], fe.Filters[i+1:]...)
+					deleteFilterFailovers(fe, filterName)
 					return nil
 				}
 			}
@@ -320,14 +373,21 @@ func (c *Config) Render() error {
 
 // save the JSON config to disk
 func (c *Config) Persist() error {
+
+	// every persisted change is a new revision of the config, it is saved along so it survives a restart
+	c.Revision++
+
 	b, err := json.Marshal(c)
 	if err != nil {
+		c.Revision--
 		return err
 	}
 	err = ioutil.WriteFile(c.JsonFile, b, 0666)
 	if err != nil {
+		c.Revision--
 		return err
 	}
+
 	return nil
 }
 
@@ -368,8 +428,22 @@ func (c *Config) BackendExists(name string) bool {
 	return false
 }
 
-/*	Helper function to check if a Backend is used by a Frontend as a default backend or a filter destination
- */
+// renders the redispatch setting of a backend. An empty string means the setting of the defaults
+// section in the template applies.
+func (b *Backend) RedispatchOption() string {
+
+	if b.Redispatch == nil {
+		return ""
+	}
+	if *b.Redispatch {
+		return "option redispatch"
+	}
+	return "no option redispatch"
+}
+
+/*	Helper function to check if a Backend is used by a Frontend as a default backend, a filter destination
+	or in a failover
+*/
 func (c *Config) BackendUsed(name string) *Error {
 
 	if c.BackendExists(name) {
@@ -382,6 +456,11 @@ func (c *Config) BackendUsed(name string) *Error {
 					return &Error{400, errors.New("Backend still in use by: " + frontend.Name + ".Filters." + filter.Name)}
 				}
 			}
+			for _, failover := range frontend.Failovers {
+				if failover.Backend == name || failover.Destination == name {
+					return &Error{400, errors.New("Backend still in use by: " + frontend.Name + ".Failovers." + failover.Name)}
+				}
+			}
 		}
 
 	}
@@ -456,3 +535,22 @@ func ServerName(routeName string, serviceName string) string {
 func FilterName(routeName string, filterDestination string) string {
 	return routeName + SEPARATOR + filterDestination
 }
+
+func FailoverName(routeName string, serviceName string) string {
+	return routeName + SEPARATOR + serviceName + SEPARATOR + "down"
+}
+
+func MirrorName(routeName string, serviceName string) string {
+	return routeName + SEPARATOR + serviceName + SEPARATOR + "mirror"
+}
+
+// checks if any frontend mirrors traffic, in which case the template needs to load the Lua mirror action
+func (c *Config) HasMirrors() bool {
+
+	for _, frontend := range c.Frontends {
+		if frontend.Mirror != nil {
+			return true
+		}
+	}
+	return false
+}
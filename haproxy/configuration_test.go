@@ -307,6 +307,11 @@ func TestConfiguration_Persist(t *testing.T) {
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
+
+	persisted := Config{JsonFile: JSON_FILE}
+	if err := persisted.GetConfigFromDisk(); err != nil || persisted.Revision != haConfig.Revision {
+		t.Errorf("Failed to persist the revision of the config")
+	}
 	os.Remove(CONFIG_FILE)
 	os.Remove(JSON_FILE)
 }
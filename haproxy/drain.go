@@ -0,0 +1,199 @@
+package haproxy
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	DRAINING = "draining"
+	REMOVED  = "removed"
+	FAILED   = "failed"
+)
+
+var (
+	// how often the sessions of a draining service or server are polled
+	drainInterval = 1 * time.Second
+	// how long finished drains are kept before they are pruned
+	drainRetention = 1 * time.Hour
+)
+
+/*
+  A Drain tracks the graceful removal of a service or a server. New traffic is stopped by setting the
+  weight of the socket server of a service to 0 and putting its servers in drain state. The current
+  sessions are polled until they reach zero or the timeout expires. Only then the service or server is
+  deleted from the config and Haproxy is reloaded.
+*/
+type Drain struct {
+	Route    string     `json:"route"`
+	Service  string     `json:"service"`
+	Server   string     `json:"server,omitempty"`
+	Status   string     `json:"status"`
+	Sessions int        `json:"sessions"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Timeout  string     `json:"timeout"`
+	TimedOut bool       `json:"timedOut"`
+	Error    string     `json:"error,omitempty"`
+	pxname   string
+	svname   string
+}
+
+// gets all drains, running and finished
+func (c *Config) GetDrains() []*Drain {
+	return c.Drains
+}
+
+// starts the graceful removal of a service from a route
+func (c *Config) DrainRouteService(r RuntimeProvider, routeName string, serviceName string, timeout time.Duration) (*Drain, *Error) {
+
+	if !c.ServiceExists(routeName, serviceName) {
+		return nil, &Error{404, errors.New("no service found")}
+	}
+
+	backendName := BackendName(routeName, serviceName)
+	drain := &Drain{Route: routeName, Service: serviceName, pxname: backendName, svname: "BACKEND"}
+
+	if err := c.addDrain(drain, timeout); err != nil {
+		return nil, err
+	}
+
+	// the socket server on the stable backend takes all traffic balanced by weight
+	socketServer := ServerName(routeName, serviceName)
+	if err := commandError(r.SetWeight(routeName, socketServer, 0)); err != nil {
+		return nil, c.failDrain(drain, err)
+	}
+	c.SetWeight(routeName, socketServer, 0)
+
+	// filters and failovers bypass the socket server, so all servers of the service are drained as well
+	servers, _ := c.GetServers(backendName)
+	for _, srv := range servers {
+		if srv.Slot && len(srv.SlotServer) == 0 {
+			continue
+		}
+		if err := commandError(r.SetServerState(backendName, srv.Name, "drain")); err != nil {
+			return nil, c.failDrain(drain, err)
+		}
+		srv.State = "drain"
+	}
+
+	go c.drain(r, drain, drain.Started.Add(timeout))
+	return drain, nil
+}
+
+// starts the graceful removal of a server from a service
+func (c *Config) DrainServiceServer(r RuntimeProvider, routeName string, serviceName string, serverName string, timeout time.Duration) (*Drain, *Error) {
+
+	if !c.ServerExists(routeName, serviceName, serverName) {
+		return nil, &Error{404, errors.New("no server found")}
+	}
+
+	// for services with slots, Haproxy knows the server by the name of its slot
+	backendName := BackendName(routeName, serviceName)
+	slotName := c.BackendServerName(routeName, serviceName, serverName)
+	drain := &Drain{Route: routeName, Service: serviceName, Server: serverName, pxname: backendName, svname: slotName}
+
+	if err := c.addDrain(drain, timeout); err != nil {
+		return nil, err
+	}
+
+	if err := commandError(r.SetServerState(backendName, slotName, "drain")); err != nil {
+		return nil, c.failDrain(drain, err)
+	}
+	c.SetServerState(backendName, slotName, "drain")
+
+	go c.drain(r, drain, drain.Started.Add(timeout))
+	return drain, nil
+}
+
+/*
+  Adds a drain before any traffic is stopped, so a drain that fails halfway is still tracked. Replaces a
+  finished drain of the same service or server and prunes drains that finished longer than the retention ago.
+*/
+func (c *Config) addDrain(drain *Drain, timeout time.Duration) *Error {
+
+	drains := []*Drain{}
+	for _, d := range c.Drains {
+		if d.Route == drain.Route && d.Service == drain.Service && d.Server == drain.Server {
+			if d.Status == DRAINING {
+				return &Error{409, errors.New("already draining")}
+			}
+			continue
+		}
+		if d.Finished != nil && time.Since(*d.Finished) > drainRetention {
+			continue
+		}
+		drains = append(drains, d)
+	}
+
+	drain.Status = DRAINING
+	drain.Started = time.Now()
+	drain.Timeout = timeout.String()
+	c.Drains = append(drains, drain)
+	return nil
+}
+
+// marks a drain as failed when traffic to the service or server could not be stopped
+func (c *Config) failDrain(drain *Drain, err error) *Error {
+	drain.finish(FAILED, err.Error())
+	return &Error{500, err}
+}
+
+func (d *Drain) finish(status string, err string) {
+	now := time.Now()
+	d.Status = status
+	d.Error = err
+	d.Finished = &now
+}
+
+// polls the current sessions of a drain until they reach zero or the deadline passes. Runs in its own
+// goroutine, so it takes the write lock on the config itself.
+func (c *Config) drain(r RuntimeProvider, drain *Drain, deadline time.Time) {
+
+	for {
+		sessions, err := r.GetSessions(drain.pxname, drain.svname)
+
+		c.BeginWriteTrans()
+		if err == nil {
+			drain.Sessions = sessions
+		}
+
+		if (err == nil && sessions == 0) || time.Now().After(deadline) {
+			drain.TimedOut = err != nil || sessions > 0
+			c.removeDrained(r, drain)
+			c.EndWriteTrans()
+			return
+		}
+		c.EndWriteTrans()
+
+		time.Sleep(drainInterval)
+	}
+}
+
+// deletes a drained service or server and reloads Haproxy
+func (c *Config) removeDrained(r RuntimeProvider, drain *Drain) {
+
+	var err *Error
+	if len(drain.Server) > 0 {
+		err = c.DeleteServiceServer(drain.Route, drain.Service, drain.Server)
+	} else {
+		err = c.DeleteRouteService(drain.Route, drain.Service)
+	}
+
+	if err != nil {
+		drain.finish(FAILED, err.Error())
+		return
+	}
+
+	if err := c.RenderAndPersist(); err != nil {
+		drain.finish(FAILED, "Error rendering config file")
+		return
+	}
+
+	if err := r.Reload(c); err != nil {
+		drain.finish(FAILED, "Error reloading the HAproxy configuration")
+		return
+	}
+
+	drain.finish(REMOVED, "")
+}
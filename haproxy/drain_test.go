@@ -0,0 +1,136 @@
+package haproxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfiguration_DrainServiceServer(t *testing.T) {
+
+	drainInterval = 10 * time.Millisecond
+
+	conf := Config{
+		WorkingDir:   "/tmp",
+		TemplateFile: TEMPLATE_FILE,
+		ConfigFile:   "/tmp/vamp_drain_test.cfg",
+		JsonFile:     "/tmp/vamp_drain_test.json",
+	}
+	conf.InitializeConfig()
+
+	route := Route{
+		Name:     "drain_route",
+		Port:     9034,
+		Protocol: "http",
+		Services: []*Service{
+			&Service{Name: "service_a", Weight: 100, Servers: []*Server{
+				&Server{Name: "server_a", Host: "192.168.2.2", Port: 8081},
+				&Server{Name: "server_b", Host: "192.168.2.2", Port: 8082},
+			}},
+		},
+	}
+
+	if err := conf.AddRoute(route); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	runtime := newFakeRuntime(t, "/tmp/vamp_drain_test.sock")
+	defer runtime.Close()
+	runtime.Reload(&conf)
+	runtime.Socket.SetCounter(BackendName("drain_route", "service_a"), "server_a", "scur", 2, 1, 0)
+
+	conf.BeginWriteTrans()
+	drain, err := conf.DrainServiceServer(runtime, "drain_route", "service_a", "server_a", 5*time.Second)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if srv, _ := conf.GetServer(BackendName("drain_route", "service_a"), "server_a"); srv.State != "drain" {
+		t.Errorf("Failed to set server in drain state")
+	}
+
+	if _, err := conf.DrainServiceServer(runtime, "drain_route", "service_a", "server_a", 5*time.Second); err == nil || err.Code != 409 {
+		t.Errorf("Draining a server twice should fail")
+	}
+	conf.EndWriteTrans()
+
+	for i := 0; i < 100; i++ {
+		conf.BeginReadTrans()
+		status := drain.Status
+		conf.EndReadTrans()
+		if status != DRAINING {
+			break
+		}
+		time.Sleep(drainInterval)
+	}
+
+	conf.BeginReadTrans()
+	defer conf.EndReadTrans()
+
+	if drain.Status != REMOVED || drain.TimedOut {
+		t.Fatalf("Failed to drain server: %v", drain)
+	}
+
+	if conf.ServerExists("drain_route", "service_a", "server_a") || !conf.ServerExists("drain_route", "service_a", "server_b") {
+		t.Errorf("Failed to remove only the drained server")
+	}
+}
+
+func TestConfiguration_DrainNonExistent(t *testing.T) {
+
+	conf := Config{WorkingDir: "/tmp"}
+	conf.InitializeConfig()
+	runtime := Runtime{SockFile: "/tmp/non_existent.sock"}
+
+	if _, err := conf.DrainRouteService(&runtime, "non_existent_route", "service_a", time.Second); err == nil || err.Code != 404 {
+		t.Errorf("Draining a non-existent service should fail")
+	}
+
+	if _, err := conf.DrainServiceServer(&runtime, "non_existent_route", "service_a", "server_a", time.Second); err == nil || err.Code != 404 {
+		t.Errorf("Draining a non-existent server should fail")
+	}
+}
+
+func TestConfiguration_DrainFailed(t *testing.T) {
+
+	conf := Config{WorkingDir: "/tmp"}
+	conf.InitializeConfig()
+
+	route := Route{
+		Name:     "drain_failed_route",
+		Port:     9035,
+		Protocol: "http",
+		Services: []*Service{
+			&Service{Name: "service_a", Weight: 100, Servers: []*Server{
+				&Server{Name: "server_a", Host: "192.168.2.2", Port: 8081},
+				&Server{Name: "server_b", Host: "192.168.2.2", Port: 8082},
+			}},
+		},
+	}
+
+	if err := conf.AddRoute(route); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// the runtime is not reloaded, so Haproxy does not know the backend yet
+	runtime := newFakeRuntime(t, "/tmp/vamp_drain_failed_test.sock")
+	defer runtime.Close()
+
+	if _, err := conf.DrainServiceServer(runtime, "drain_failed_route", "service_a", "server_a", time.Second); err == nil || err.Code != 500 {
+		t.Fatalf("Draining a server unknown to Haproxy should fail")
+	}
+
+	drains := conf.GetDrains()
+	if len(drains) != 1 || drains[0].Status != FAILED || drains[0].Finished == nil || len(drains[0].Error) == 0 {
+		t.Fatalf("Failed to track the failed drain: %v", drains)
+	}
+
+	// finished drains are pruned once the retention has passed
+	finished := time.Now().Add(-2 * drainRetention)
+	drains[0].Finished = &finished
+
+	conf.DrainServiceServer(runtime, "drain_failed_route", "service_a", "server_b", time.Second)
+
+	if drains = conf.GetDrains(); len(drains) != 1 || drains[0].Server != "server_b" {
+		t.Errorf("Failed to prune the finished drain: %v", drains)
+	}
+}
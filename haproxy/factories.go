@@ -22,6 +22,7 @@ func (c *Config) frontendFactory(name string, mode string, port int, filter []*F
 		Filters:        filter,
 		HttpQuota:      Quota{},
 		TcpQuota:       Quota{},
+		Failovers:      []*Failover{},
 	}
 }
 
@@ -38,7 +39,7 @@ func (c *Config) backendFactory(name string, mode string, proxy bool, servers []
 }
 
 // creates a ServerDetail object
-func (c *Config) serverFactory(name string, weight int, host string, port int) *ServerDetail {
+func (c *Config) serverFactory(name string, weight int, host string, port int, backup bool) *ServerDetail {
 	return &ServerDetail{
 		Name:          name,
 		Host:          host,
@@ -48,6 +49,7 @@ func (c *Config) serverFactory(name string, weight int, host string, port int) *
 		MaxConn:       1000,
 		Check:         false,
 		CheckInterval: 10,
+		Backup:        backup,
 	}
 }
 
@@ -64,6 +66,7 @@ func (c *Config) socketFrontendFactory(name string, mode string, socket string,
 		Filters:        []*Filter{},
 		HttpQuota:      Quota{},
 		TcpQuota:       Quota{},
+		Failovers:      []*Failover{},
 	}
 }
 
@@ -0,0 +1,124 @@
+package haproxy
+
+import (
+	"errors"
+)
+
+/*
+  A service in a route can name another service of the same route as its failover. As soon as the backend
+  of the service has no usable servers left, its traffic is sent to the backend of the failover service.
+  This is done using nbsrv ACLs in two places:
+
+  1. The socket frontend of the service, catching the weighted traffic coming from the stable backend.
+  2. The stable frontend of the route, catching the traffic that filters send straight to the service backend.
+*/
+
+// checks if the failover of a service points to another service in the given set of services
+func validateFailover(service *Service, services []*Service) *Error {
+
+	if len(service.Failover) == 0 {
+		return nil
+	}
+
+	if service.Failover == service.Name {
+		return &Error{400, errors.New("Service cannot fail over to itself: " + service.Name)}
+	}
+
+	for _, svc := range services {
+		if svc.Name == service.Failover {
+			return nil
+		}
+	}
+	return &Error{400, errors.New("No failover service found: " + service.Failover)}
+}
+
+// sets the failovers for a service on its socket frontend and on the stable frontend of the route
+func resolveFailovers(routeName string, service *Service, stableFrontend *Frontend, socketFrontend *Frontend) {
+
+	if len(service.Failover) == 0 {
+		return
+	}
+
+	name := FailoverName(routeName, service.Name)
+	backend := BackendName(routeName, service.Name)
+	destination := BackendName(routeName, service.Failover)
+
+	socketFrontend.Failovers = append(socketFrontend.Failovers, &Failover{name, backend, destination, "", false})
+
+	for _, filter := range stableFrontend.Filters {
+		if filter.Destination == backend {
+			stableFrontend.Failovers = append(stableFrontend.Failovers, &Failover{name, backend, destination, filter.Name, filter.Negate})
+		}
+	}
+}
+
+/*
+  Checks that no failover sends traffic to the backend of a service, as the backend can not be deleted while
+  a failover refers to it. Failovers guarding one of the ignored backends are deleted along with them.
+*/
+func (c *Config) checkFailoverTarget(routeName string, serviceName string, ignored ...string) *Error {
+
+	backend := BackendName(routeName, serviceName)
+
+	for _, fe := range c.Frontends {
+		for _, failover := range fe.Failovers {
+			if failover.Destination != backend {
+				continue
+			}
+			used := true
+			for _, name := range ignored {
+				if failover.Backend == name {
+					used = false
+				}
+			}
+			if used {
+				return &Error{400, errors.New("Service is still the failover of: " + failover.Backend)}
+			}
+		}
+	}
+	return nil
+}
+
+// removes all failovers from a frontend that guard a specific backend
+func (c *Config) deleteFailovers(frontendName string, backendName string) {
+
+	for _, fe := range c.Frontends {
+		if fe.Name == frontendName {
+			failovers := []*Failover{}
+			for _, failover := range fe.Failovers {
+				if failover.Backend != backendName {
+					failovers = append(failovers, failover)
+				}
+			}
+			fe.Failovers = failovers
+		}
+	}
+}
+
+/*
+  Adds a failover for the traffic of a new filter, when the backend it sends to has a failover. The failover
+  of a backend is the one without a filter, set on the socket frontend of its service.
+*/
+func (c *Config) addFilterFailover(frontend *Frontend, filter *Filter) {
+
+	for _, fe := range c.Frontends {
+		for _, failover := range fe.Failovers {
+			if failover.Backend == filter.Destination && len(failover.Filter) == 0 {
+				frontend.Failovers = append(frontend.Failovers, &Failover{failover.Name, failover.Backend, failover.Destination, filter.Name, filter.Negate})
+				return
+			}
+		}
+	}
+}
+
+// removes the failovers from a frontend that use a filter, as they refer to its ACL
+func deleteFilterFailovers(frontend *Frontend, filterName string) {
+
+	failovers := []*Failover{}
+	for _, failover := range frontend.Failovers {
+		if failover.Filter != filterName {
+			failovers = append(failovers, failover)
+		}
+	}
+	frontend.Failovers = failovers
+}
@@ -0,0 +1,485 @@
+package haproxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the stats columns reported by the fake stats socket, in the order of Haproxy 1.5
+var fakeStatsColumns = []string{"pxname", "svname", "qcur", "qmax", "scur", "smax", "slim", "stot", "bin", "bout",
+	"dreq", "dresp", "ereq", "econ", "eresp", "wretr", "wredis", "status", "weight", "act", "bck", "chkfail",
+	"chkdown", "lastchg", "downtime", "qlimit", "pid", "iid", "sid", "throttle", "lbtot", "tracked", "type", "rate",
+	"rate_lim", "rate_max", "check_status", "check_code", "check_duration", "hrsp_1xx", "hrsp_2xx", "hrsp_3xx",
+	"hrsp_4xx", "hrsp_5xx", "hrsp_other", "hanafail", "req_rate", "req_rate_max", "req_tot", "cli_abrt", "srv_abrt",
+	"comp_in", "comp_out", "comp_byp", "comp_rsp", "lastsess", "last_chk", "last_agt", "qtime", "ctime", "rtime",
+	"ttime"}
+
+// the proxy types as reported in the "type" column of the stats
+const (
+	fakeFrontend = iota
+	fakeBackend
+	fakeServer
+)
+
+/*
+  The FakeStatsSocket is an in-process stand-in for the stats socket of Haproxy. It speaks the same protocol,
+  one command per connection or many in interactive mode, so the Runtime talks to it like to a real Haproxy.
+  It supports:
+
+  - show stat [-1 <type> -1]
+  - show info
+  - show servers state
+  - set weight <backend>/<server> <weight>
+  - set server <backend>/<server> state <ready|drain|maint>
+  - set server <backend>/<server> addr <host> port <port>
+  - clear counters [all]
+
+  New server addresses are confirmed the way Haproxy 1.8+ does. Counters are scripted per proxy and field. Every
+  "show stat" reports the next value of a script, the last value is repeated. Other columns can be set to a fixed
+  value.
+*/
+type FakeStatsSocket struct {
+	SockFile string
+	listener net.Listener
+	proxies  []*fakeProxy
+	info     map[string]string
+	mutex    sync.Mutex
+}
+
+type fakeProxy struct {
+	pxname   string
+	svname   string
+	kind     int
+	weight   int
+	state    string
+	addr     string
+	port     int
+	counters map[string]*fakeCounter
+	fields   map[string]string
+}
+
+type fakeCounter struct {
+	values []int
+	next   int
+}
+
+// starts a fake stats socket listening on a unix socket
+func NewFakeStatsSocket(sockFile string) (*FakeStatsSocket, error) {
+
+	os.Remove(sockFile)
+	listener, err := net.Listen("unix", sockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FakeStatsSocket{
+		SockFile: sockFile,
+		listener: listener,
+		proxies:  []*fakeProxy{},
+		info: map[string]string{
+			"Name":       "HAProxy",
+			"Version":    "1.5.fake",
+			"Nbproc":     "1",
+			"Pid":        strconv.Itoa(os.Getpid()),
+			"Uptime_sec": "0",
+			"Maxconn":    "4000",
+			"CurrConns":  "0",
+		},
+	}
+
+	go s.serve()
+	return s, nil
+}
+
+func (s *FakeStatsSocket) Close() error {
+	return s.listener.Close()
+}
+
+func (s *FakeStatsSocket) AddFrontend(name string) {
+	s.add(&fakeProxy{pxname: name, svname: "FRONTEND", kind: fakeFrontend})
+}
+
+func (s *FakeStatsSocket) AddBackend(name string) {
+	s.add(&fakeProxy{pxname: name, svname: "BACKEND", kind: fakeBackend})
+}
+
+func (s *FakeStatsSocket) AddServer(backend string, server string, weight int) {
+	s.add(&fakeProxy{pxname: backend, svname: server, kind: fakeServer, weight: weight, state: "ready"})
+}
+
+// scripts the values of a counter, i.e. "scur", of a proxy. Every "show stat" reports the next value.
+func (s *FakeStatsSocket) SetCounter(pxname string, svname string, field string, values ...int) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	proxy := s.find(pxname, svname)
+	if proxy == nil {
+		return errors.New("no such proxy: " + pxname + ":" + svname)
+	}
+	proxy.counters[field] = &fakeCounter{values: values}
+	return nil
+}
+
+// sets a column of a proxy to a fixed value, i.e. the "status" of a server failing its health checks
+func (s *FakeStatsSocket) SetField(pxname string, svname string, field string, value string) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	proxy := s.find(pxname, svname)
+	if proxy == nil {
+		return errors.New("no such proxy: " + pxname + ":" + svname)
+	}
+	proxy.fields[field] = value
+	return nil
+}
+
+func (s *FakeStatsSocket) SetInfo(key string, value string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.info[key] = value
+}
+
+/*
+  Replaces the proxies by the frontends, backends and servers of a config, like a reload of Haproxy would.
+  Counter scripts and fixed columns of proxies that are still there are kept.
+*/
+func (s *FakeStatsSocket) Sync(c *Config) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	old := s.proxies
+	s.proxies = []*fakeProxy{}
+
+	keep := func(proxy *fakeProxy) {
+		for _, p := range old {
+			if p.pxname == proxy.pxname && p.svname == proxy.svname {
+				proxy.counters = p.counters
+				proxy.fields = p.fields
+			}
+		}
+		s.addLocked(proxy)
+	}
+
+	for _, fe := range c.Frontends {
+		keep(&fakeProxy{pxname: fe.Name, svname: "FRONTEND", kind: fakeFrontend})
+	}
+
+	for _, be := range c.Backends {
+		for _, srv := range be.Servers {
+			state := srv.State
+			if len(state) == 0 {
+				state = "ready"
+			}
+			keep(&fakeProxy{pxname: be.Name, svname: srv.Name, kind: fakeServer, weight: srv.Weight, state: state, addr: srv.Host, port: srv.Port})
+		}
+		keep(&fakeProxy{pxname: be.Name, svname: "BACKEND", kind: fakeBackend})
+	}
+}
+
+func (s *FakeStatsSocket) add(proxy *fakeProxy) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.addLocked(proxy)
+}
+
+func (s *FakeStatsSocket) addLocked(proxy *fakeProxy) {
+	if proxy.counters == nil {
+		proxy.counters = make(map[string]*fakeCounter)
+	}
+	if proxy.fields == nil {
+		proxy.fields = make(map[string]string)
+	}
+	s.proxies = append(s.proxies, proxy)
+}
+
+func (s *FakeStatsSocket) find(pxname string, svname string) *fakeProxy {
+	for _, p := range s.proxies {
+		if p.pxname == pxname && p.svname == svname {
+			return p
+		}
+	}
+	return nil
+}
+
+func (s *FakeStatsSocket) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.session(conn)
+	}
+}
+
+// serves one command per connection, or many in interactive mode until "quit" or the client disconnects
+func (s *FakeStatsSocket) session(conn net.Conn) {
+
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	interactive := false
+
+	for {
+		cmd, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		switch cmd = strings.TrimSpace(cmd); {
+		case cmd == "quit":
+			return
+		case cmd == "prompt":
+			interactive = true
+			fmt.Fprint(conn, socketPrompt)
+			continue
+		}
+
+		reply := s.handle(cmd)
+		if !interactive {
+			fmt.Fprint(conn, reply)
+			return
+		}
+		fmt.Fprint(conn, reply+"> ")
+	}
+}
+
+// handles one command and returns the response, which like with Haproxy ends with an empty line
+func (s *FakeStatsSocket) handle(cmd string) string {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fields := strings.Fields(cmd)
+	switch {
+	case strings.HasPrefix(cmd, "show stat"):
+		return s.showStat(fields[2:])
+	case cmd == "show info":
+		return s.showInfo()
+	case cmd == "show servers state":
+		return s.showServersState()
+	case len(fields) == 4 && fields[0] == "set" && fields[1] == "weight":
+		return s.setWeight(fields[2], fields[3])
+	case len(fields) >= 5 && fields[0] == "set" && fields[1] == "server":
+		return s.setServer(fields[2], fields[3:])
+	case strings.HasPrefix(cmd, "clear counters"):
+		for _, p := range s.proxies {
+			p.counters = make(map[string]*fakeCounter)
+		}
+		return "\n"
+	}
+	return "Unknown command.\n\n"
+}
+
+func (s *FakeStatsSocket) showStat(args []string) string {
+
+	// the type filter is a bitmask, 1 for frontends, 2 for backends and 4 for servers
+	types := 7
+	if len(args) == 3 {
+		if t, err := strconv.Atoi(args[1]); err == nil && t > 0 {
+			types = t
+		}
+	}
+
+	out := "# " + strings.Join(fakeStatsColumns, ",") + ",\n"
+	for _, p := range s.proxies {
+		if types&(1<<uint(p.kind)) == 0 {
+			continue
+		}
+		values := []string{}
+		for _, column := range fakeStatsColumns {
+			values = append(values, p.stat(column))
+		}
+		out += strings.Join(values, ",") + ",\n"
+	}
+	return out + "\n"
+}
+
+func (p *fakeProxy) stat(column string) string {
+
+	if counter, ok := p.counters[column]; ok && len(counter.values) > 0 {
+		value := counter.values[len(counter.values)-1]
+		if counter.next < len(counter.values) {
+			value = counter.values[counter.next]
+			counter.next++
+		}
+		return strconv.Itoa(value)
+	}
+
+	if value, ok := p.fields[column]; ok {
+		return value
+	}
+
+	switch column {
+	case "pxname":
+		return p.pxname
+	case "svname":
+		return p.svname
+	case "type":
+		return strconv.Itoa(p.kind)
+	case "pid", "iid":
+		return "1"
+	case "status":
+		switch {
+		case p.kind == fakeFrontend:
+			return "OPEN"
+		case p.kind == fakeServer && p.state == "drain":
+			return "DRAIN"
+		case p.kind == fakeServer && p.state == "maint":
+			return "MAINT"
+		}
+		return "UP"
+	case "weight":
+		if p.kind == fakeServer {
+			return strconv.Itoa(p.weight)
+		}
+		return ""
+	case "check_status", "check_code", "check_duration", "tracked", "throttle", "last_chk", "last_agt":
+		return ""
+	}
+	return "0"
+}
+
+func (s *FakeStatsSocket) showInfo() string {
+
+	out := ""
+	for key, value := range s.info {
+		out += key + ": " + value + "\n"
+	}
+	return out + "\n"
+}
+
+// reports the servers with the op and admin state codes of Haproxy, 2 running, 0 ready, 1 maint, 8 drain
+func (s *FakeStatsSocket) showServersState() string {
+
+	out := "1\n# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight\n"
+	for i, p := range s.proxies {
+		if p.kind != fakeServer {
+			continue
+		}
+		admin := "0"
+		switch p.state {
+		case "maint":
+			admin = "1"
+		case "drain":
+			admin = "8"
+		}
+		out += fmt.Sprintf("1 %s %d %s %s 2 %s %d %d\n", p.pxname, i+1, p.svname, p.addr, admin, p.weight, p.weight)
+	}
+	return out + "\n"
+}
+
+func (s *FakeStatsSocket) server(name string) (*fakeProxy, string) {
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 {
+		return nil, "Require 'backend/server'.\n\n"
+	}
+
+	backend := s.find(parts[0], "BACKEND")
+	if backend == nil {
+		return nil, "No such backend.\n\n"
+	}
+
+	server := s.find(parts[0], parts[1])
+	if server == nil || server.kind != fakeServer {
+		return nil, "No such server.\n\n"
+	}
+	return server, ""
+}
+
+func (s *FakeStatsSocket) setWeight(name string, weight string) string {
+
+	server, reply := s.server(name)
+	if server == nil {
+		return reply
+	}
+
+	w, err := strconv.Atoi(weight)
+	if err != nil || w < 0 || w > 256 {
+		return "Integer value expected.\n\n"
+	}
+	server.weight = w
+	return "\n"
+}
+
+func (s *FakeStatsSocket) setServer(name string, args []string) string {
+
+	server, reply := s.server(name)
+	if server == nil {
+		return reply
+	}
+
+	switch {
+	case args[0] == "state" && len(args) == 2:
+		switch args[1] {
+		case "ready", "drain", "maint":
+			server.state = args[1]
+			return "\n"
+		}
+		return "'set server <srv> state' expects 'ready', 'drain' and 'maint'.\n\n"
+	case args[0] == "addr" && len(args) == 4 && args[2] == "port":
+		port, err := strconv.Atoi(args[3])
+		if err != nil {
+			return "Invalid port.\n\n"
+		}
+		if server.addr == args[1] && server.port == port {
+			return "no need to change the addr and port.\n\n"
+		}
+		reply := fmt.Sprintf("IP changed from '%s' to '%s', port changed from '%d' to '%d' by 'stats socket command'\n\n",
+			server.addr, args[1], server.port, port)
+		server.addr = args[1]
+		server.port = port
+		return reply
+	}
+	return "'set server <srv>' only supports 'agent', 'health', 'state', 'weight' and 'addr'.\n\n"
+}
+
+/*
+  The FakeRuntime is a RuntimeProvider backed by a FakeStatsSocket instead of a Haproxy process. Reloads
+  only sync the fake socket with the config, so the API and metrics can be run and tested without Haproxy.
+*/
+type FakeRuntime struct {
+	*Runtime
+	Socket      *FakeStatsSocket
+	Reloads     int
+	ReloadError error
+}
+
+func NewFakeRuntime(sockFile string) (*FakeRuntime, error) {
+
+	socket, err := NewFakeStatsSocket(sockFile)
+	if err != nil {
+		return nil, err
+	}
+	return &FakeRuntime{Runtime: &Runtime{SockFile: sockFile}, Socket: socket}, nil
+}
+
+func (f *FakeRuntime) Reload(c *Config) error {
+
+	if f.ReloadError != nil {
+		return f.ReloadError
+	}
+
+	start := time.Now()
+	f.Socket.Sync(c)
+	f.Reloads++
+	f.LastReload = &ReloadReport{Time: start, Seamless: true, Duration: time.Since(start).String()}
+	return nil
+}
+
+func (f *FakeRuntime) Status(c *Config) RuntimeStatus {
+	return RuntimeStatus{Reload: f.LastReload, Workers: []Worker{}, Reloads: f.Reloads}
+}
+
+func (f *FakeRuntime) Close() error {
+	return f.Socket.Close()
+}
@@ -0,0 +1,133 @@
+package haproxy
+
+import (
+	"testing"
+)
+
+// starts a fake runtime and fails the test when its socket cannot be opened
+func newFakeRuntime(t *testing.T, sock string) *FakeRuntime {
+
+	runtime, err := NewFakeRuntime(sock)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return runtime
+}
+
+func fakeRuntimeConfig(t *testing.T) *Config {
+
+	conf := &Config{WorkingDir: "/tmp"}
+	conf.InitializeConfig()
+
+	route := Route{
+		Name:     "fake_route",
+		Port:     9037,
+		Protocol: "http",
+		Services: []*Service{
+			&Service{Name: "service_a", Weight: 100, Servers: []*Server{
+				&Server{Name: "server_a", Host: "192.168.2.2", Port: 8081},
+			}},
+		},
+	}
+
+	if err := conf.AddRoute(route); err != nil {
+		t.Fatal(err.Error())
+	}
+	return conf
+}
+
+func TestFakeRuntime_Stats(t *testing.T) {
+
+	conf := fakeRuntimeConfig(t)
+	runtime := newFakeRuntime(t, "/tmp/vamp_fake_runtime_test.sock")
+	defer runtime.Close()
+
+	if err := runtime.Reload(conf); err != nil || runtime.Reloads != 1 {
+		t.Fatalf("Failed to reload the fake runtime")
+	}
+
+	backendName := BackendName("fake_route", "service_a")
+	runtime.Socket.SetCounter(backendName, "server_a", "scur", 3, 5)
+
+	for _, expected := range []string{"3", "5", "5"} {
+		stats, err := runtime.GetStats("all")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if scur := stats[backendName+":server_a"]["scur"]; scur != expected {
+			t.Errorf("Expected scripted scur %s, got %s", expected, scur)
+		}
+	}
+
+	servers, err := runtime.GetJsonStats("server")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, server := range servers {
+		if server.Svname == "FRONTEND" || server.Svname == "BACKEND" {
+			t.Errorf("Failed to filter the server stats: %v", server)
+		}
+	}
+
+	typed, err := runtime.GetProxyStats("server")
+	if err != nil || len(typed) != len(servers) || typed[0].Type != SERVER_TYPE || typed[0].Status != STATUS_UP {
+		t.Errorf("Failed to get the typed server stats: %v", typed)
+	}
+
+	frontends, err := runtime.GetJsonStats("frontend")
+	if err != nil || len(frontends) != len(conf.Frontends) {
+		t.Errorf("Failed to filter the frontend stats: %v", frontends)
+	}
+
+	if info, err := runtime.GetInfo(); err != nil || info.Name != "HAProxy" {
+		t.Errorf("Failed to get the info")
+	}
+
+	if err := runtime.Reset(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if sessions, _ := runtime.GetSessions(backendName, "server_a"); sessions != 0 {
+		t.Errorf("Failed to clear the counters")
+	}
+}
+
+func TestFakeRuntime_SetWeightAndState(t *testing.T) {
+
+	conf := fakeRuntimeConfig(t)
+	runtime := newFakeRuntime(t, "/tmp/vamp_fake_runtime_test.sock")
+	defer runtime.Close()
+	runtime.Reload(conf)
+
+	backendName := BackendName("fake_route", "service_a")
+
+	if result, err := runtime.SetWeight(backendName, "server_a", 50); err != nil || result != "\n" {
+		t.Fatalf("Failed to set the weight")
+	}
+
+	if stats, _ := runtime.GetStats("server"); stats[backendName+":server_a"]["weight"] != "50" {
+		t.Errorf("Failed to report the new weight")
+	}
+
+	if _, err := runtime.SetWeight(backendName, "no_such_server", 50); err != ErrNoSuchServer {
+		t.Errorf("Setting the weight of a non-existent server should fail")
+	}
+
+	if _, err := runtime.SetWeight("no_such_backend", "server_a", 50); err != ErrNoSuchBackend {
+		t.Errorf("Setting the weight in a non-existent backend should fail")
+	}
+
+	runtime.SetServerState(backendName, "server_a", "drain")
+	if status, _ := runtime.GetServerStatus(backendName, "server_a"); status != "DRAIN" {
+		t.Errorf("Failed to drain the server, got %s", status)
+	}
+
+	runtime.SetServerState(backendName, "server_a", "maint")
+	if status, _ := runtime.GetServerStatus(backendName, "server_a"); status != "MAINT" {
+		t.Errorf("Failed to put the server in maintenance, got %s", status)
+	}
+
+	if _, err := runtime.GetServerStatus(backendName, "no_such_server"); err == nil || err.Code != 404 {
+		t.Errorf("Getting the status of a non-existent server should fail")
+	}
+}
@@ -0,0 +1,253 @@
+package haproxy
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/*
+  The master-worker manager runs Haproxy with -W as a child of the router. The master process forks the workers
+  and stays in the foreground. Reloads send SIGUSR2 to the master, which re-executes itself with the rendered
+  config and lets the old workers finish. With a master CLI socket (-S, Haproxy 1.9+), the workers are listed
+  through "show proc".
+
+      vamp-router
+        └── haproxy -W (master)     <- SIGUSR2 on reload, SIGUSR1 on stop
+              ├── worker            <- current
+              └── worker            <- old, finishing its connections
+*/
+type MasterWorkerProcess struct {
+	MasterSock   string
+	StartTimeout time.Duration
+	StopTimeout  time.Duration
+	cmd          *exec.Cmd
+	done         chan bool
+	lastExit     string
+	mutex        sync.RWMutex
+}
+
+func NewMasterWorkerProcess(masterSock string) *MasterWorkerProcess {
+	return &MasterWorkerProcess{
+		MasterSock:   masterSock,
+		StartTimeout: 5 * time.Second,
+		StopTimeout:  10 * time.Second,
+	}
+}
+
+func (p *MasterWorkerProcess) Reload(r *Runtime, c *Config, oldPid string) (bool, error) {
+
+	// processes left by an earlier run of the router are not our children, the new master takes over from them
+	if !p.running() {
+		return false, p.start(r, c, oldPid)
+	}
+
+	// a master reloading a broken config keeps running without workers, so check the config first
+	if err := checkConfig(r.Binary, c.ConfigFile); err != nil {
+		return false, err
+	}
+
+	if err := p.cmd.Process.Signal(syscall.SIGUSR2); err != nil {
+		return false, err
+	}
+
+	// the master hands the listening sockets over to the new workers by itself, the router can not tell
+	// whether it did, so the reload is not reported as seamless
+	return false, nil
+}
+
+func (p *MasterWorkerProcess) Restart(r *Runtime, c *Config) error {
+	return p.start(r, c, "")
+}
+
+// soft stops the master, which waits for the workers to finish. It is killed when that takes too long.
+func (p *MasterWorkerProcess) Stop() error {
+
+	if !p.running() {
+		return nil
+	}
+
+	if err := p.cmd.Process.Signal(syscall.SIGUSR1); err != nil {
+		return err
+	}
+
+	select {
+	case <-p.done:
+		return nil
+	case <-time.After(p.StopTimeout):
+		p.cmd.Process.Kill()
+		<-p.done
+		return errors.New("Haproxy master did not stop in time and was killed")
+	}
+}
+
+func (p *MasterWorkerProcess) Workers(c *Config) ([]Worker, error) {
+
+	workers := []Worker{}
+
+	if !p.running() {
+		return workers, nil
+	}
+
+	// without a master CLI, only the master itself is known
+	if len(p.MasterSock) == 0 {
+		return append(workers, Worker{Pid: p.cmd.Process.Pid, Type: "master"}), nil
+	}
+
+	result, err := NewStatsSocket(p.MasterSock).Cmd("show proc\n")
+	if err != nil {
+		return workers, err
+	}
+	return parseWorkers(result), nil
+}
+
+func (p *MasterWorkerProcess) LastExit() string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.lastExit
+}
+
+/*
+  Starts the master and waits until it wrote its pid. The master re-executes itself with the same arguments on
+  every reload, so it is always started with the config file the router renders to.
+*/
+func (p *MasterWorkerProcess) start(r *Runtime, c *Config, oldPid string) error {
+
+	args := []string{"-W", "-f", c.ConfigFile, "-p", c.PidFile}
+	if len(p.MasterSock) > 0 {
+		args = append(args, "-S", p.MasterSock)
+	}
+	if pids := strings.Fields(oldPid); len(pids) > 0 {
+		args = append(append(args, "-sf"), pids...)
+	}
+
+	cmd := exec.Command(r.Binary, args...)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan bool)
+	p.cmd = cmd
+	p.done = done
+
+	go func() {
+		err := cmd.Wait()
+		p.mutex.Lock()
+		p.lastExit = exitReason(err, out.String())
+		p.mutex.Unlock()
+		close(done)
+	}()
+
+	deadline := time.Now().Add(p.StartTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-done:
+			return errors.New("Haproxy master exited: " + p.LastExit())
+		default:
+		}
+
+		pids, _ := readPids(c.PidFile)
+		for _, pid := range pids {
+			if pid == cmd.Process.Pid {
+				return nil
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return errors.New("timed out waiting for the Haproxy master to start")
+}
+
+func (p *MasterWorkerProcess) running() bool {
+
+	if p.cmd == nil {
+		return false
+	}
+
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// checks a config file with "haproxy -c"
+func checkConfig(binary string, configFile string) error {
+
+	cmd := exec.Command(binary, "-c", "-f", configFile)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err != nil {
+		return errors.New("invalid Haproxy config: " + strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// describes why a process exited, including the last line it logged
+func exitReason(err error, output string) string {
+
+	reason := "exit status 0"
+	if err != nil {
+		reason = err.Error()
+	}
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if last := lines[len(lines)-1]; len(last) > 0 {
+		reason += ": " + last
+	}
+	return reason
+}
+
+/*
+  Parses the output of "show proc" on the master CLI, i.e:
+
+  #<PID>          <type>          <relative PID>  <reloads>       <uptime>
+  1162            master          0               2               0d00h02m04s
+  # workers
+  1271            worker          1               0               0d00h00m00s
+  # old workers
+  1233            worker          [was: 1]        1               0d00h00m28s
+*/
+func parseWorkers(output string) []Worker {
+
+	workers := []Worker{}
+	old := false
+
+	for _, line := range strings.Split(output, "\n") {
+
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case strings.HasPrefix(line, "#"):
+			old = strings.Contains(line, "old")
+		case len(fields) >= 5:
+			pid, err := strconv.Atoi(fields[0])
+			if err != nil {
+				continue
+			}
+			reloads, _ := strconv.Atoi(fields[len(fields)-2])
+			workers = append(workers, Worker{
+				Pid:     pid,
+				Type:    fields[1],
+				Reloads: reloads,
+				Uptime:  fields[len(fields)-1],
+				Old:     old,
+			})
+		}
+	}
+	return workers
+}
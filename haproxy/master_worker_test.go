@@ -0,0 +1,127 @@
+package haproxy
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMasterWorker_ReloadAndStop(t *testing.T) {
+
+	// a fake Haproxy master that records reloads and stops on SIGUSR1
+	binary := "/tmp/vamp_fake_master.sh"
+	script := `#!/bin/sh
+if [ "$1" = "-c" ]; then exit 0; fi
+trap 'echo reload >> /tmp/vamp_fake_master.log' USR2
+trap 'exit 0' USR1
+echo $$ > /tmp/vamp_master_test.pid
+while true; do sleep 0.05; done
+`
+	if err := ioutil.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(binary)
+
+	os.Remove("/tmp/vamp_fake_master.log")
+	defer os.Remove("/tmp/vamp_fake_master.log")
+	ioutil.WriteFile("/tmp/vamp_master_test.pid", []byte(""), 0644)
+	defer os.Remove("/tmp/vamp_master_test.pid")
+
+	process := NewMasterWorkerProcess("")
+	runtime := Runtime{Binary: binary, Process: process}
+	conf := Config{ConfigFile: "/tmp/vamp_master_test.cfg", PidFile: "/tmp/vamp_master_test.pid"}
+
+	if err := runtime.Reload(&conf); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	workers, _ := runtime.Workers(&conf)
+	if len(workers) != 1 || workers[0].Type != "master" || runtime.LastReload.NewPid == "" {
+		t.Fatalf("Failed to start the Haproxy master: %v", workers)
+	}
+
+	if err := runtime.Reload(&conf); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for i := 0; i < 40; i++ {
+		if log, _ := ioutil.ReadFile("/tmp/vamp_fake_master.log"); strings.Contains(string(log), "reload") {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if log, _ := ioutil.ReadFile("/tmp/vamp_fake_master.log"); !strings.Contains(string(log), "reload") {
+		t.Errorf("Failed to signal the Haproxy master to reload")
+	}
+
+	if err := runtime.Stop(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if process.running() || process.LastExit() != "exit status 0" {
+		t.Errorf("Failed to stop the Haproxy master: %s", process.LastExit())
+	}
+}
+
+func TestMasterWorker_Restart(t *testing.T) {
+
+	// a fake Haproxy master that records its arguments
+	binary := "/tmp/vamp_fake_restart_master.sh"
+	script := `#!/bin/sh
+echo "$@" > /tmp/vamp_fake_restart_master.log
+trap 'exit 0' USR1
+echo $$ > /tmp/vamp_restart_test.pid
+while true; do sleep 0.05; done
+`
+	if err := ioutil.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(binary)
+	defer os.Remove("/tmp/vamp_fake_restart_master.log")
+	defer os.Remove("/tmp/vamp_restart_test.pid")
+
+	process := NewMasterWorkerProcess("")
+	runtime := Runtime{Binary: binary, Process: process}
+	conf := Config{ConfigFile: "/tmp/vamp_restart_test.cfg", PidFile: "/tmp/vamp_restart_test.pid"}
+
+	ioutil.WriteFile(conf.ConfigFile, []byte("broken"), 0644)
+	defer os.Remove(conf.ConfigFile)
+	ioutil.WriteFile(knownGoodConfig(&conf), []byte("good"), 0644)
+	defer os.Remove(knownGoodConfig(&conf))
+
+	if err := runtime.Restart(&conf); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer runtime.Stop()
+
+	if config, _ := ioutil.ReadFile(conf.ConfigFile); string(config) != "good" {
+		t.Errorf("Failed to restore the known-good config, got %s", config)
+	}
+
+	// later reloads re-execute the master with the same arguments, so it must not run from the known-good copy
+	if args, _ := ioutil.ReadFile("/tmp/vamp_fake_restart_master.log"); !strings.Contains(string(args), "-f "+conf.ConfigFile+" ") {
+		t.Errorf("Failed to start the Haproxy master with the config file, got %s", args)
+	}
+}
+
+func TestMasterWorker_ParseWorkers(t *testing.T) {
+
+	output := `#<PID>          <type>          <relative PID>  <reloads>       <uptime>
+1162            master          0               2               0d00h02m04s
+# workers
+1271            worker          1               0               0d00h00m00s
+# old workers
+1233            worker          [was: 1]        1               0d00h00m28s
+`
+	workers := parseWorkers(output)
+	if len(workers) != 3 {
+		t.Fatalf("Failed to parse workers: %v", workers)
+	}
+
+	if workers[0].Type != "master" || workers[0].Reloads != 2 || workers[1].Old || !workers[2].Old || workers[2].Pid != 1233 {
+		t.Errorf("Failed to parse workers: %v", workers)
+	}
+}
@@ -0,0 +1,60 @@
+package haproxy
+
+import (
+	"errors"
+	"strconv"
+)
+
+/*
+  Mirroring a route to a service works as follows:
+
+    ->[fe (mirror) : be]-> [srv a] -> sock -> [fe a: be a] -> [*srv] -> host:port
+          \
+            -> lua.vamp_mirror -> mirror sock -> [fe mirror: be b] -> [*srv] -> host:port
+
+  The Lua action in the stable frontend queues a copy of a sample of the requests and sends it to the unix
+  socket of a dedicated mirror frontend. That frontend uses the backend of the mirrored service, so its stats
+  show exactly how many requests were mirrored. Responses to the copies are discarded by the Lua action.
+*/
+
+// checks that a route does not mirror to a service, as its mirror frontend refers to the backend of the service
+func checkMirrorTarget(route Route, serviceName string) *Error {
+
+	if route.Mirror != nil && route.Mirror.Service == serviceName {
+		return &Error{400, errors.New("Service is still mirrored by route: " + route.Name)}
+	}
+	return nil
+}
+
+// checks the mirror of a route, creates the mirror frontend and sets the resolved mirror on the stable frontend.
+func (c *Config) resolveMirror(route Route, stableFrontend *Frontend, backends []*Backend) (*Frontend, *Error) {
+
+	mirror := route.Mirror
+
+	if route.Protocol != "http" {
+		return nil, &Error{400, errors.New("Mirroring is only supported for http routes")}
+	}
+
+	if mirror.Percentage < 1 || mirror.Percentage > 100 {
+		return nil, &Error{400, errors.New("Mirror percentage should be between 1 and 100, got: " + strconv.Itoa(mirror.Percentage))}
+	}
+
+	for _, backend := range backends {
+		if backend.Name == BackendName(route.Name, mirror.Service) {
+
+			name := MirrorName(route.Name, mirror.Service)
+			frontend := c.socketFrontendFactory(name, route.Protocol, compileSocketName(c.WorkingDir, name), backend)
+
+			// the Lua action sends plain HTTP, without the proxy protocol
+			frontend.SockProtocol = ""
+
+			stableFrontend.Mirror = &Mirror{
+				Service:    backend.Name,
+				Percentage: mirror.Percentage,
+				UnixSock:   frontend.UnixSock,
+			}
+			return frontend, nil
+		}
+	}
+	return nil, &Error{400, errors.New("No mirror service found: " + mirror.Service)}
+}
@@ -0,0 +1,126 @@
+package haproxy
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+/*
+  A ProcessManager starts, reloads and stops the Haproxy processes. The classic manager daemonizes Haproxy and
+  reloads it by starting a new process that takes over from the old one. The master-worker manager keeps a
+  Haproxy master process as a child of the router and reloads it by signalling the master.
+
+  The Runtime uses the classic manager unless another one is set, so the rest of the router does not need to
+  know which one is used.
+*/
+type ProcessManager interface {
+
+	// starts Haproxy, or hands over to a new configuration when oldPid holds the running processes.
+	// Returns true when the listening sockets were passed on seamlessly.
+	Reload(r *Runtime, c *Config, oldPid string) (bool, error)
+
+	// starts Haproxy from the config file after all its processes stopped
+	Restart(r *Runtime, c *Config) error
+
+	// stops Haproxy when the router shuts down
+	Stop() error
+
+	// lists the Haproxy processes
+	Workers(c *Config) ([]Worker, error)
+
+	// the reason the last Haproxy process exited, empty when unknown
+	LastExit() string
+}
+
+// A Haproxy process
+type Worker struct {
+	Pid     int    `json:"pid"`
+	Type    string `json:"type"`
+	Reloads int    `json:"reloads"`
+	Uptime  string `json:"uptime,omitempty"`
+	Old     bool   `json:"old"`
+}
+
+// The classic manager runs Haproxy daemonized with -D and reloads it with -sf
+type ClassicProcess struct{}
+
+func (p *ClassicProcess) Reload(r *Runtime, c *Config, oldPid string) (bool, error) {
+
+	/*  Setup all the command line parameters so we get an executable similar to
+	    /usr/local/bin/haproxy -f resources/haproxy_new.cfg -p resources/haproxy-private.pid -sf 1234
+
+	*/
+	arg0 := "-f"
+	arg1 := c.ConfigFile
+	arg2 := "-p"
+	arg3 := c.PidFile
+	arg4 := "-D"
+	arg5 := "-sf"
+	arg6 := oldPid
+	var cmd *exec.Cmd
+
+	// fmt.Println(r.Binary + " " + arg0 + " " + arg1 + " " + arg2 + " " + arg3 + " " + arg4 + " " + arg5 + " " + arg6)
+	// If this is the first run, the PID value will be empty, otherwise it will be > 0. For seamless reloads
+	// the new process takes over the listening sockets of the old one through the stats socket.
+	seamless := c.SeamlessReload && len(arg6) > 0
+	if seamless {
+		cmd = exec.Command(r.Binary, arg0, arg1, arg2, arg3, arg4, "-x", c.SockFile, arg5, arg6)
+	} else if len(arg6) > 0 {
+		cmd = exec.Command(r.Binary, arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+	} else {
+		cmd = exec.Command(r.Binary, arg0, arg1, arg2, arg3, arg4)
+	}
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+
+	// with -D the command returns once the new process is bound and has signalled the old one
+	if err := cmd.Run(); err != nil {
+		return false, err
+	}
+	return seamless, nil
+}
+
+// Unlike a reload, no old process is signalled, as its pid may be reused by another process by now.
+func (p *ClassicProcess) Restart(r *Runtime, c *Config) error {
+
+	cmd := exec.Command(r.Binary, "-f", c.ConfigFile, "-p", c.PidFile, "-D")
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err != nil {
+		return errors.New(err.Error() + ": " + strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// a daemonized Haproxy keeps running when the router stops
+func (p *ClassicProcess) Stop() error {
+	return nil
+}
+
+func (p *ClassicProcess) Workers(c *Config) ([]Worker, error) {
+
+	workers := []Worker{}
+
+	pids, err := readPids(c.PidFile)
+	if err != nil {
+		return workers, err
+	}
+
+	for _, pid := range pids {
+		if pidAlive(pid) {
+			workers = append(workers, Worker{Pid: pid, Type: "worker"})
+		}
+	}
+	return workers, nil
+}
+
+// daemonized processes are not our children, so their exit status is unknown
+func (p *ClassicProcess) LastExit() string {
+	return ""
+}
@@ -0,0 +1,152 @@
+package haproxy
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	HEALTH_UP       = "up"
+	HEALTH_DEGRADED = "degraded"
+	HEALTH_DOWN     = "down"
+)
+
+/*
+  The health of a route, combining its config with the live stats of its servers:
+
+  - a service is up when none of its servers is down, degraded when some are and down when none is up.
+    Servers in maintenance or draining were taken out on purpose, they do not count as down.
+  - a route is up when all services with a weight are up, down when none of them is up and degraded
+    otherwise. Services without a weight get no traffic, so they only count when no service has a weight.
+*/
+type RouteHealth struct {
+	Route    string           `json:"route"`
+	Status   string           `json:"status"`
+	Services []*ServiceHealth `json:"services"`
+}
+
+type ServiceHealth struct {
+	Service string          `json:"service"`
+	Status  string          `json:"status"`
+	Weight  int             `json:"weight"`
+	Up      int             `json:"up"`
+	Down    int             `json:"down"`
+	Maint   int             `json:"maint"`
+	Drain   int             `json:"drain"`
+	Servers []*ServerHealth `json:"servers"`
+}
+
+type ServerHealth struct {
+	Server      string      `json:"server"`
+	Status      ProxyStatus `json:"status"`
+	CheckStatus string      `json:"checkStatus,omitempty"`
+	CheckCode   string      `json:"checkCode,omitempty"`
+	LastCheck   string      `json:"lastCheck,omitempty"`
+	SinceChange Duration    `json:"sinceChange"`
+}
+
+// gets the health of a route from the running Haproxy
+func (c *Config) GetRouteHealth(r RuntimeProvider, routeName string) (*RouteHealth, *Error) {
+
+	route, err := c.GetRoute(routeName)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, statsErr := r.GetProxyStats("server")
+	if statsErr != nil {
+		return nil, &Error{500, errors.New("Error getting stats: " + statsErr.Error())}
+	}
+
+	proxies := make(map[string]*ProxyStats)
+	for _, s := range stats {
+		proxies[s.Proxy+":"+s.Server] = s
+	}
+
+	health := &RouteHealth{Route: route.Name, Services: []*ServiceHealth{}}
+
+	for _, service := range route.Services {
+
+		backendName := BackendName(route.Name, service.Name)
+		serviceHealth := &ServiceHealth{Service: service.Name, Weight: service.Weight, Servers: []*ServerHealth{}}
+
+		servers, _ := c.GetServers(backendName)
+		for _, srv := range servers {
+
+			// free slots are no servers of the service
+			name := srv.Name
+			if srv.Slot {
+				if len(srv.SlotServer) == 0 {
+					continue
+				}
+				name = srv.SlotServer
+			}
+
+			// servers missing from the stats, i.e. not reloaded yet, count as down
+			serverHealth := &ServerHealth{Server: name, Status: STATUS_DOWN}
+			if s, ok := proxies[backendName+":"+srv.Name]; ok {
+				serverHealth.Status = s.Status
+				serverHealth.CheckStatus = s.Value("check_status")
+				serverHealth.CheckCode = s.Value("check_code")
+				serverHealth.LastCheck = s.Value("last_chk")
+				serverHealth.SinceChange = Duration(time.Duration(s.Counters["lastchg"]) * time.Second)
+			}
+
+			switch serverHealth.Status {
+			case STATUS_UP, STATUS_NO_CHECK:
+				serviceHealth.Up++
+			case STATUS_MAINT:
+				serviceHealth.Maint++
+			case STATUS_DRAIN, STATUS_NOLB:
+				serviceHealth.Drain++
+			default:
+				serviceHealth.Down++
+			}
+			serviceHealth.Servers = append(serviceHealth.Servers, serverHealth)
+		}
+
+		switch {
+		case serviceHealth.Up == 0:
+			serviceHealth.Status = HEALTH_DOWN
+		case serviceHealth.Down > 0:
+			serviceHealth.Status = HEALTH_DEGRADED
+		default:
+			serviceHealth.Status = HEALTH_UP
+		}
+		health.Services = append(health.Services, serviceHealth)
+	}
+
+	health.Status = routeHealthStatus(health.Services)
+	return health, nil
+}
+
+func routeHealthStatus(services []*ServiceHealth) string {
+
+	weighted := []*ServiceHealth{}
+	for _, service := range services {
+		if service.Weight > 0 {
+			weighted = append(weighted, service)
+		}
+	}
+	if len(weighted) == 0 {
+		weighted = services
+	}
+
+	up, down := 0, 0
+	for _, service := range weighted {
+		switch service.Status {
+		case HEALTH_UP:
+			up++
+		case HEALTH_DOWN:
+			down++
+		}
+	}
+
+	switch {
+	case len(weighted) == 0 || down == len(weighted):
+		return HEALTH_DOWN
+	case up == len(weighted):
+		return HEALTH_UP
+	}
+	return HEALTH_DEGRADED
+}
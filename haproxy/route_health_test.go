@@ -0,0 +1,75 @@
+package haproxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfiguration_GetRouteHealth(t *testing.T) {
+
+	conf := Config{WorkingDir: "/tmp"}
+	conf.InitializeConfig()
+
+	route := Route{
+		Name:     "health_route",
+		Port:     9039,
+		Protocol: "http",
+		Services: []*Service{
+			&Service{Name: "service_a", Weight: 100, Servers: []*Server{
+				&Server{Name: "server_a", Host: "192.168.2.2", Port: 8081},
+				&Server{Name: "server_b", Host: "192.168.2.3", Port: 8081},
+				&Server{Name: "server_c", Host: "192.168.2.4", Port: 8081, State: "maint"},
+			}},
+			&Service{Name: "service_b", Weight: 0, Servers: []*Server{
+				&Server{Name: "server_d", Host: "192.168.2.5", Port: 8081},
+			}},
+		},
+	}
+
+	if err := conf.AddRoute(route); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	runtime := newFakeRuntime(t, "/tmp/vamp_route_health_test.sock")
+	defer runtime.Close()
+	runtime.Reload(&conf)
+
+	backendA := BackendName("health_route", "service_a")
+	backendB := BackendName("health_route", "service_b")
+
+	// a down service without weight does not affect the route
+	runtime.Socket.SetField(backendB, "server_d", "status", "DOWN")
+
+	health, err := conf.GetRouteHealth(runtime, "health_route")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if health.Status != HEALTH_UP || health.Services[0].Up != 2 || health.Services[0].Maint != 1 || health.Services[1].Status != HEALTH_DOWN {
+		t.Errorf("Expected the route to be up, got %v", health)
+	}
+
+	runtime.Socket.SetField(backendA, "server_a", "status", "DOWN 1/2")
+	runtime.Socket.SetField(backendA, "server_a", "check_status", "L4CON")
+	runtime.Socket.SetCounter(backendA, "server_a", "lastchg", 30)
+
+	health, _ = conf.GetRouteHealth(runtime, "health_route")
+	if health.Status != HEALTH_DEGRADED || health.Services[0].Down != 1 {
+		t.Errorf("Expected the route to be degraded, got %v", health)
+	}
+
+	if server := health.Services[0].Servers[0]; server.CheckStatus != "L4CON" || time.Duration(server.SinceChange) != 30*time.Second {
+		t.Errorf("Failed to report the last check of the server: %v", server)
+	}
+
+	runtime.Socket.SetField(backendA, "server_b", "status", "DOWN")
+
+	health, _ = conf.GetRouteHealth(runtime, "health_route")
+	if health.Status != HEALTH_DOWN {
+		t.Errorf("Expected the route to be down, got %v", health)
+	}
+
+	if _, err := conf.GetRouteHealth(runtime, "non_existent"); err == nil || err.Code != 404 {
+		t.Errorf("Getting the health of a non-existent route should fail")
+	}
+}
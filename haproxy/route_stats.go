@@ -0,0 +1,167 @@
+package haproxy
+
+import (
+	"errors"
+)
+
+/*
+  The stats of a route, reassembled from the stats of the Haproxy proxies it consists of:
+
+      route frontend "route"               -> Frontend
+      route backend "route"
+        └── socket server "route::service" -> TrafficShare of the service
+      service backend "route::service"     -> Traffic of the service
+        └── server "server"                -> Traffic of the server
+
+  The internal socket frontends of the services are left out, their traffic equals that of the service
+  backends.
+*/
+type RouteStats struct {
+	Route    string          `json:"route"`
+	Frontend TrafficStats    `json:"frontend"`
+	Services []*ServiceStats `json:"services"`
+}
+
+type ServiceStats struct {
+	Service      string         `json:"service"`
+	Weight       int            `json:"weight"`
+	WeightShare  float64        `json:"weightShare"`
+	TrafficShare float64        `json:"trafficShare"`
+	Traffic      TrafficStats   `json:"traffic"`
+	Servers      []*ServerStats `json:"servers"`
+}
+
+type ServerStats struct {
+	Server  string       `json:"server"`
+	Status  ProxyStatus  `json:"status,omitempty"`
+	Weight  int64        `json:"weight"`
+	Traffic TrafficStats `json:"traffic"`
+}
+
+// the traffic related parts of the stats of a proxy
+type TrafficStats struct {
+	Sessions  SessionStats  `json:"sessions"`
+	Queue     QueueStats    `json:"queue"`
+	Requests  RequestStats  `json:"requests"`
+	Responses ResponseStats `json:"responses"`
+	Errors    ErrorStats    `json:"errors"`
+	Timings   TimingStats   `json:"timings"`
+}
+
+func trafficStats(s *ProxyStats) TrafficStats {
+
+	if s == nil {
+		return TrafficStats{}
+	}
+	return TrafficStats{s.Sessions, s.Queue, s.Requests, s.Responses, s.Errors, s.Timings}
+}
+
+// gets the stats of a route from the running Haproxy
+func (c *Config) GetRouteStats(r RuntimeProvider, routeName string) (*RouteStats, *Error) {
+
+	route, err := c.GetRoute(routeName)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, statsErr := r.GetProxyStats("all")
+	if statsErr != nil {
+		return nil, &Error{500, errors.New("Error getting stats: " + statsErr.Error())}
+	}
+
+	proxies := make(map[string]*ProxyStats)
+	for _, s := range stats {
+		proxies[s.Proxy+":"+s.Server] = s
+	}
+
+	routeStats := &RouteStats{
+		Route:    route.Name,
+		Frontend: trafficStats(proxies[route.Name+":FRONTEND"]),
+		Services: []*ServiceStats{},
+	}
+
+	var totalWeight int
+	var totalSessions int64
+
+	for _, service := range route.Services {
+
+		backendName := BackendName(route.Name, service.Name)
+		serviceStats := &ServiceStats{
+			Service: service.Name,
+			Weight:  service.Weight,
+			Traffic: trafficStats(proxies[backendName+":BACKEND"]),
+			Servers: []*ServerStats{},
+		}
+
+		servers, _ := c.GetServers(backendName)
+		for _, srv := range servers {
+
+			// free slots are no servers of the service
+			name := srv.Name
+			if srv.Slot {
+				if len(srv.SlotServer) == 0 {
+					continue
+				}
+				name = srv.SlotServer
+			}
+
+			serverStats := &ServerStats{Server: name, Weight: int64(srv.Weight)}
+			if s, ok := proxies[backendName+":"+srv.Name]; ok {
+				serverStats.Status = s.Status
+				serverStats.Weight = s.Weight
+				serverStats.Traffic = trafficStats(s)
+			}
+			serviceStats.Servers = append(serviceStats.Servers, serverStats)
+		}
+
+		totalWeight += service.Weight
+		if s, ok := proxies[route.Name+":"+ServerName(route.Name, service.Name)]; ok {
+			totalSessions += s.Sessions.Total
+		}
+		routeStats.Services = append(routeStats.Services, serviceStats)
+	}
+
+	// the traffic share is the share of all sessions the route sent to a service, in percent
+	for _, serviceStats := range routeStats.Services {
+		if totalWeight > 0 {
+			serviceStats.WeightShare = 100 * float64(serviceStats.Weight) / float64(totalWeight)
+		}
+		if s, ok := proxies[route.Name+":"+ServerName(route.Name, serviceStats.Service)]; ok && totalSessions > 0 {
+			serviceStats.TrafficShare = 100 * float64(s.Sessions.Total) / float64(totalSessions)
+		}
+	}
+
+	return routeStats, nil
+}
+
+// gets the stats of a service of a route from the running Haproxy
+func (c *Config) GetRouteServiceStats(r RuntimeProvider, routeName string, serviceName string) (*ServiceStats, *Error) {
+
+	routeStats, err := c.GetRouteStats(r, routeName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, serviceStats := range routeStats.Services {
+		if serviceStats.Service == serviceName {
+			return serviceStats, nil
+		}
+	}
+	return nil, &Error{404, errors.New("no service found")}
+}
+
+// gets the stats of a server of a service from the running Haproxy
+func (c *Config) GetServiceServerStats(r RuntimeProvider, routeName string, serviceName string, serverName string) (*ServerStats, *Error) {
+
+	serviceStats, err := c.GetRouteServiceStats(r, routeName, serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, serverStats := range serviceStats.Servers {
+		if serverStats.Server == serverName {
+			return serverStats, nil
+		}
+	}
+	return nil, &Error{404, errors.New("no server found")}
+}
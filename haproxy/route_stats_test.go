@@ -0,0 +1,68 @@
+package haproxy
+
+import (
+	"testing"
+)
+
+func TestConfiguration_GetRouteStats(t *testing.T) {
+
+	conf := Config{WorkingDir: "/tmp"}
+	conf.InitializeConfig()
+
+	route := Route{
+		Name:     "stats_route",
+		Port:     9038,
+		Protocol: "http",
+		Services: []*Service{
+			&Service{Name: "service_a", Weight: 30, Servers: []*Server{
+				&Server{Name: "server_a", Host: "192.168.2.2", Port: 8081},
+			}},
+			&Service{Name: "service_b", Weight: 70, Slots: 2, Servers: []*Server{
+				&Server{Name: "server_b", Host: "192.168.2.3", Port: 8081},
+			}},
+		},
+	}
+
+	if err := conf.AddRoute(route); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	runtime := newFakeRuntime(t, "/tmp/vamp_route_stats_test.sock")
+	defer runtime.Close()
+	runtime.Reload(&conf)
+
+	runtime.Socket.SetCounter("stats_route", ServerName("stats_route", "service_a"), "stot", 25)
+	runtime.Socket.SetCounter("stats_route", ServerName("stats_route", "service_b"), "stot", 75)
+	runtime.Socket.SetCounter(BackendName("stats_route", "service_b"), SlotName(1), "hrsp_5xx", 3)
+	runtime.Socket.SetCounter("stats_route", "FRONTEND", "req_rate", 12)
+
+	stats, err := conf.GetRouteStats(runtime, "stats_route")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if stats.Frontend.Requests.Rate != 12 || len(stats.Services) != 2 {
+		t.Fatalf("Failed to get the route stats: %v", stats)
+	}
+
+	if a := stats.Services[0]; a.WeightShare != 30 || a.TrafficShare != 25 {
+		t.Errorf("Expected a weight share of 30 and traffic share of 25, got %v and %v", a.WeightShare, a.TrafficShare)
+	}
+
+	b := stats.Services[1]
+	if len(b.Servers) != 1 || b.Servers[0].Server != "server_b" || b.Servers[0].Traffic.Responses.Hrsp5xx != 3 {
+		t.Errorf("Failed to map the slots back to the servers: %v", b.Servers)
+	}
+
+	if server, err := conf.GetServiceServerStats(runtime, "stats_route", "service_b", "server_b"); err != nil || server.Status != STATUS_UP {
+		t.Errorf("Failed to get the server stats")
+	}
+
+	if _, err := conf.GetRouteServiceStats(runtime, "stats_route", "non_existent"); err == nil || err.Code != 404 {
+		t.Errorf("Getting the stats of a non-existent service should fail")
+	}
+
+	if _, err := conf.GetRouteStats(runtime, "non_existent"); err == nil || err.Code != 404 {
+		t.Errorf("Getting the stats of a non-existent route should fail")
+	}
+}
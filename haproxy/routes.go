@@ -49,6 +49,9 @@ func (c *Config) AddRoute(route Route) *Error {
 	// 3. Create stable Frontend and add the stable Backend to it
 
 	stableBackend := c.backendFactory(route.Name, route.Protocol, true, []*ServerDetail{})
+	stableBackend.Timeouts = route.Timeouts
+	stableBackend.Retries = route.Retries
+	stableBackend.Redispatch = route.Redispatch
 	beSlice = append(beSlice, stableBackend)
 
 	// 4. As an extra step, we need to replace the destination in any filters with the full backend name
@@ -59,6 +62,7 @@ func (c *Config) AddRoute(route Route) *Error {
 	}
 
 	stableFrontend := c.frontendFactory(route.Name, route.Protocol, route.Port, resolvedFilters, stableBackend)
+	stableFrontend.Timeouts = route.Timeouts
 	feSlice = append(feSlice, stableFrontend)
 	/*
 
@@ -72,6 +76,14 @@ func (c *Config) AddRoute(route Route) *Error {
 
 	for _, service := range route.Services {
 
+		if valid, err := Validate(service); valid != true {
+			return &Error{400, err}
+		}
+
+		if err := validateFailover(service, route.Services); err != nil {
+			return err
+		}
+
 		socketServer := c.socketServerFactory(ServerName(route.Name, service.Name), service.Weight)
 		stableBackend.Servers = append(stableBackend.Servers, socketServer)
 
@@ -81,15 +93,33 @@ func (c *Config) AddRoute(route Route) *Error {
 		frontend := c.socketFrontendFactory(FrontendName(route.Name, service.Name), route.Protocol, socketServer.UnixSock, backend)
 		feSlice = append(feSlice, frontend)
 
+		applyServiceSettings(route, service, frontend, backend)
+		resolveFailovers(route.Name, service, stableFrontend, frontend)
+
 		/*
 			for servers
 				1. Create Server, with a default weight.
 				2. Add Server to Backend Servers slice
 		*/
 		for _, server := range service.Servers {
-			srv := c.serverFactory(server.Name, DEFAULT_WEIGHT, server.Host, server.Port)
+			srv := c.serverFactory(server.Name, DEFAULT_WEIGHT, server.Host, server.Port, server.Backup)
+			srv.State = server.State
 			backend.Servers = append(backend.Servers, srv)
 		}
+		if service.Slots > 0 {
+			c.provisionSlots(backend, service.Slots)
+		}
+		backend.Options.AllBackups = service.AllBackups
+	}
+
+	// 5. When the route mirrors traffic, create the mirror frontend for the mirrored service and point the
+	//    stable Frontend to it.
+	if route.Mirror != nil {
+		mirrorFrontend, err := c.resolveMirror(route, stableFrontend, beSlice)
+		if err != nil {
+			return err
+		}
+		feSlice = append(feSlice, mirrorFrontend)
 	}
 
 	for _, fe := range feSlice {
@@ -115,9 +145,16 @@ func (c *Config) DeleteRoute(name string) *Error {
 			// first remove the single frontend, getting rid of filters and other pointers to backends
 			c.DeleteFrontend(route.Name)
 
-			// then remove all the frontends and backends related to the services
+			if route.Mirror != nil {
+				c.DeleteFrontend(MirrorName(route.Name, route.Mirror.Service))
+			}
+
+			// then remove all the frontends and backends related to the services. All frontends go first, as
+			// failovers can make the frontend of one service point to the backend of another.
 			for _, service := range route.Services {
 				c.DeleteFrontend(FrontendName(route.Name, service.Name))
+			}
+			for _, service := range route.Services {
 				c.DeleteBackend(BackendName(route.Name, service.Name))
 			}
 
@@ -178,20 +215,42 @@ func (c *Config) AddRouteServices(routeName string, services []*Service) *Error
 		if c.ServiceExists(routeName, service.Name) {
 			return nil
 		}
+		if valid, err := Validate(service); valid != true {
+			return &Error{400, err}
+		}
 	}
 
-	for _, route := range c.Routes {
+	for i := range c.Routes {
+		route := &c.Routes[i]
 		if route.Name == routeName {
 
+			for _, service := range services {
+				if err := validateFailover(service, append(route.Services, services...)); err != nil {
+					return err
+				}
+			}
+
+			stableFrontend, err := c.GetFrontend(route.Name)
+			if err != nil {
+				return err
+			}
+
 			for _, service := range services {
 				socketServer := c.socketServerFactory(ServerName(routeName, service.Name), service.Weight)
 				backend := c.backendFactory(BackendName(route.Name, service.Name), route.Protocol, false, []*ServerDetail{})
 				frontend := c.socketFrontendFactory(FrontendName(route.Name, service.Name), route.Protocol, socketServer.UnixSock, backend)
+				applyServiceSettings(*route, service, frontend, backend)
+				resolveFailovers(route.Name, service, stableFrontend, frontend)
 
 				for _, server := range service.Servers {
-					srv := c.serverFactory(server.Name, service.Weight, server.Host, server.Port)
+					srv := c.serverFactory(server.Name, service.Weight, server.Host, server.Port, server.Backup)
+					srv.State = server.State
 					backend.Servers = append(backend.Servers, srv)
 				}
+				if service.Slots > 0 {
+					c.provisionSlots(backend, service.Slots)
+				}
+				backend.Options.AllBackups = service.AllBackups
 
 				if err := c.AddBackend(backend); err != nil {
 					return &Error{500, errors.New("something went wrong adding backend: " + backend.Name)}
@@ -212,13 +271,24 @@ func (c *Config) AddRouteServices(routeName string, services []*Service) *Error
 
 func (c *Config) DeleteRouteService(routeName string, serviceName string) *Error {
 
-	for _, rt := range c.Routes {
+	for i := range c.Routes {
+		rt := &c.Routes[i]
 		if rt.Name == routeName {
 			for j, srv := range rt.Services {
 				if srv.Name == serviceName {
 
-					// order is important here. Always delete frontends first because they hold references to
-					// backends. Deleting a backend that is still referenced first will fail.
+					if err := c.checkFailoverTarget(routeName, serviceName); err != nil {
+						return err
+					}
+
+					if err := checkMirrorTarget(*rt, serviceName); err != nil {
+						return err
+					}
+
+					// order is important here. Always delete frontends and failovers first because they hold
+					// references to backends. Deleting a backend that is still referenced first will fail.
+					c.deleteFailovers(routeName, BackendName(routeName, serviceName))
+
 					if err := c.DeleteFrontend(FrontendName(routeName, serviceName)); err != nil {
 						return &Error{500, errors.New("Something went wrong deleting frontend: " + FrontendName(routeName, serviceName))}
 					}
@@ -253,6 +323,30 @@ func (c *Config) UpdateRouteService(routeName string, serviceName string, servic
 
 func (c *Config) UpdateRouteServices(routeName string, services []*Service) *Error {
 
+	backends := []string{}
+	for _, srv := range services {
+		backends = append(backends, BackendName(routeName, srv.Name))
+	}
+
+	// a mirrored service can not be replaced, a failover only together with the services failing over to it
+	for _, srv := range services {
+		if err := c.checkFailoverTarget(routeName, srv.Name, backends...); err != nil {
+			return err
+		}
+		if route, err := c.GetRoute(routeName); err == nil {
+			if err := checkMirrorTarget(route, srv.Name); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the failovers between the replaced services refer to each other's backends, drop them before deleting
+	for _, fe := range c.Frontends {
+		for _, backend := range backends {
+			c.deleteFailovers(fe.Name, backend)
+		}
+	}
+
 	for _, srv := range services {
 		if err := c.DeleteRouteService(routeName, srv.Name); err != nil {
 			return err
@@ -310,7 +404,11 @@ func (c *Config) DeleteServiceServer(routeName string, serviceName string, serve
 				if grp.Name == serviceName {
 					for i, srv := range grp.Servers {
 						if srv.Name == serverName {
-							if err := c.DeleteServer(BackendName(routeName, serviceName), serverName); err != nil {
+							if grp.Slots > 0 {
+								if err := c.releaseSlot(BackendName(routeName, serviceName), serverName); err != nil {
+									return err
+								}
+							} else if err := c.DeleteServer(BackendName(routeName, serviceName), serverName); err != nil {
 								return &Error{500, err}
 							}
 							grp.Servers = append(grp.Servers[:i], grp.Servers[i+1:]...)
@@ -334,8 +432,15 @@ func (c *Config) AddServiceServer(routeName string, serviceName string, server *
 		if route.Name == routeName {
 			for _, service := range route.Services {
 				if service.Name == serviceName {
-					srvDetail := c.serverFactory(server.Name, service.Weight, server.Host, server.Port)
-					c.AddServer(BackendName(routeName, serviceName), srvDetail)
+					if service.Slots > 0 {
+						if err := c.fillSlot(BackendName(routeName, serviceName), server, service.Weight, service.Slots); err != nil {
+							return err
+						}
+					} else {
+						srvDetail := c.serverFactory(server.Name, service.Weight, server.Host, server.Port, server.Backup)
+						srvDetail.State = server.State
+						c.AddServer(BackendName(routeName, serviceName), srvDetail)
+					}
 					service.Servers = append(service.Servers, server)
 					return nil
 				}
@@ -345,6 +450,17 @@ func (c *Config) AddServiceServer(routeName string, serviceName string, server *
 	return &Error{404, errors.New("no service found")}
 }
 
+// updates the admin state of a server in a service and of its counterpart in the service backend
+func (c *Config) SetServiceServerState(routeName string, serviceName string, serverName string, state string) *Error {
+
+	if _, err := c.GetServiceServer(routeName, serviceName, serverName); err != nil {
+		return err
+	}
+
+	// setting the state of the backend server updates the server of the service as well
+	return c.SetServerState(BackendName(routeName, serviceName), c.BackendServerName(routeName, serviceName, serverName), state)
+}
+
 // just a convenience functions for a delete and a create
 func (c *Config) UpdateServiceServer(routeName string, serviceName string, serverName string, server *Server) *Error {
 
@@ -357,3 +473,55 @@ func (c *Config) UpdateServiceServer(routeName string, serviceName string, serve
 	}
 	return nil
 }
+
+/*
+  Applies the timeouts, retries and redispatch settings of a route and one of its services to the socket
+  frontend and backend of that service. Settings on the service take precedence over the ones on the route.
+
+  Note that the stable frontend and backend of a route only carry the route settings, so a service timeout
+  longer than the route timeout will still be cut off by the first tier of the route.
+*/
+func applyServiceSettings(route Route, service *Service, frontend *Frontend, backend *Backend) {
+
+	timeouts := route.Timeouts.merge(service.Timeouts)
+	frontend.Timeouts = timeouts
+	backend.Timeouts = timeouts
+
+	backend.Retries = route.Retries
+	if service.Retries != nil {
+		backend.Retries = service.Retries
+	}
+
+	backend.Redispatch = route.Redispatch
+	if service.Redispatch != nil {
+		backend.Redispatch = service.Redispatch
+	}
+}
+
+// merges two sets of timeouts. Any value that is set in the override wins.
+func (t Timeouts) merge(override Timeouts) Timeouts {
+
+	result := t
+	if len(override.Connect) > 0 {
+		result.Connect = override.Connect
+	}
+	if len(override.Client) > 0 {
+		result.Client = override.Client
+	}
+	if len(override.Server) > 0 {
+		result.Server = override.Server
+	}
+	if len(override.HttpRequest) > 0 {
+		result.HttpRequest = override.HttpRequest
+	}
+	if len(override.HttpKeepAlive) > 0 {
+		result.HttpKeepAlive = override.HttpKeepAlive
+	}
+	if len(override.Tunnel) > 0 {
+		result.Tunnel = override.Tunnel
+	}
+	if len(override.Queue) > 0 {
+		result.Queue = override.Queue
+	}
+	return result
+}
@@ -289,3 +289,252 @@ func TestConfiguration_DeleteRoute(t *testing.T) {
 		t.Errorf("Should return nil on non existent route")
 	}
 }
+
+func TestConfiguration_RouteTimeouts(t *testing.T) {
+
+	conf := Config{WorkingDir: "/tmp"}
+	conf.InitializeConfig()
+
+	retries := 1
+	redispatch := false
+	route := Route{
+		Name:       "timeout_route",
+		Port:       9030,
+		Protocol:   "http",
+		Timeouts:   Timeouts{Client: "1h", Server: "1h"},
+		Retries:    &retries,
+		Redispatch: &redispatch,
+		Services: []*Service{
+			&Service{Name: "service_a", Weight: 100, Timeouts: Timeouts{Server: "10m", Tunnel: "1h"}},
+		},
+	}
+
+	if err := conf.AddRoute(route); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if fe, _ := conf.GetFrontend("timeout_route"); fe.Timeouts.Client != "1h" {
+		t.Errorf("Failed to set route timeouts on the stable frontend")
+	}
+
+	backend, _ := conf.GetBackend(BackendName("timeout_route", "service_a"))
+	if backend.Timeouts.Server != "10m" || backend.Timeouts.Tunnel != "1h" || backend.Timeouts.Client != "1h" {
+		t.Errorf("Service timeouts should take precedence over route timeouts: %v", backend.Timeouts)
+	}
+
+	if *backend.Retries != 1 || backend.RedispatchOption() != "no option redispatch" {
+		t.Errorf("Failed to set retries and redispatch on service backend")
+	}
+
+	route.Name = "timeout_route_wrong"
+	route.Timeouts = Timeouts{Client: "one hour"}
+	if err := conf.AddRoute(route); err == nil {
+		t.Errorf("Adding should fail using a non-valid timeout")
+	}
+}
+
+func TestConfiguration_RouteFailover(t *testing.T) {
+
+	conf := Config{WorkingDir: "/tmp"}
+	conf.InitializeConfig()
+
+	route := Route{
+		Name:     "failover_route",
+		Port:     9031,
+		Protocol: "http",
+		Filters: []*Filter{
+			&Filter{Name: "uses_msie", Condition: "hdr_sub(user-agent) MSIE", Destination: "service_a"},
+		},
+		Services: []*Service{
+			&Service{Name: "service_a", Weight: 100, Failover: "service_b", AllBackups: true, Servers: []*Server{
+				&Server{Name: "server_a", Host: "192.168.2.2", Port: 8081},
+				&Server{Name: "server_a_backup", Host: "192.168.2.2", Port: 8082, Backup: true},
+			}},
+			&Service{Name: "service_b", Weight: 0},
+		},
+	}
+
+	if err := conf.AddRoute(route); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	socketFrontend, _ := conf.GetFrontend(FrontendName("failover_route", "service_a"))
+	if len(socketFrontend.Failovers) != 1 || socketFrontend.Failovers[0].Destination != BackendName("failover_route", "service_b") {
+		t.Errorf("Failed to set failover on the socket frontend")
+	}
+
+	stableFrontend, _ := conf.GetFrontend("failover_route")
+	if len(stableFrontend.Failovers) != 1 || stableFrontend.Failovers[0].Filter != "uses_msie" {
+		t.Errorf("Failed to set failover for filtered traffic on the stable frontend")
+	}
+
+	if backend, _ := conf.GetBackend(BackendName("failover_route", "service_a")); !backend.Options.AllBackups || !backend.Servers[1].Backup {
+		t.Errorf("Failed to set backup servers")
+	}
+
+	if err := conf.DeleteRouteService("failover_route", "service_b"); err == nil || err.Code != 400 {
+		t.Errorf("Deleting a service that is still used as a failover should fail with a 400")
+	}
+
+	if err := conf.UpdateRouteService("failover_route", "service_b", &Service{Name: "service_b", Weight: 0}); err == nil || err.Code != 400 {
+		t.Errorf("Updating a service that is still used as a failover should fail with a 400")
+	}
+
+	if route, _ := conf.GetRoute("failover_route"); len(route.Services) != 2 || !conf.FrontendExists(FrontendName("failover_route", "service_b")) || !conf.BackendExists(BackendName("failover_route", "service_b")) {
+		t.Errorf("Failed to leave the route untouched when refusing to delete a failover service")
+	}
+
+	conf.DeleteFilter("failover_route", "uses_msie")
+	if len(stableFrontend.Failovers) != 0 {
+		t.Errorf("Failed to remove the failover of a deleted filter from the stable frontend")
+	}
+
+	// replacing a failover service together with the service failing over to it sets up the failover again
+	services := []*Service{
+		&Service{Name: "service_b", Weight: 0},
+		&Service{Name: "service_a", Weight: 100, Failover: "service_b"},
+	}
+	if err := conf.UpdateRouteServices("failover_route", services); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	socketFrontend, _ = conf.GetFrontend(FrontendName("failover_route", "service_a"))
+	if len(socketFrontend.Failovers) != 1 {
+		t.Errorf("Failed to set up the failover of replaced services")
+	}
+
+	filter := &Filter{Name: "uses_firefox", Condition: "hdr_sub(user-agent) Mozilla", Destination: BackendName("failover_route", "service_a")}
+	conf.AddFilter("failover_route", filter)
+	if len(stableFrontend.Failovers) != 1 || stableFrontend.Failovers[0].Filter != "uses_firefox" || stableFrontend.Failovers[0].Destination != BackendName("failover_route", "service_b") {
+		t.Errorf("Failed to set the failover of a new filter on the stable frontend")
+	}
+
+	conf.DeleteFilter("failover_route", "uses_firefox")
+	if err := conf.DeleteRouteService("failover_route", "service_a"); err != nil {
+		t.Error(err.Error())
+	}
+
+	if len(stableFrontend.Failovers) != 0 {
+		t.Errorf("Failed to remove failovers from the stable frontend")
+	}
+
+	wrongServices := []*Service{&Service{Name: "service_c", Weight: 100, Failover: "non_existent_service"}}
+	if err := conf.AddRouteServices("failover_route", wrongServices); err == nil {
+		t.Errorf("Adding a service with a non existent failover should fail")
+	}
+}
+
+func TestConfiguration_RouteMirror(t *testing.T) {
+
+	conf := Config{WorkingDir: "/tmp"}
+	conf.InitializeConfig()
+
+	route := Route{
+		Name:     "mirror_route",
+		Port:     9032,
+		Protocol: "http",
+		Mirror:   &Mirror{Service: "service_b", Percentage: 10},
+		Services: []*Service{
+			&Service{Name: "service_a", Weight: 100},
+			&Service{Name: "service_b", Weight: 0},
+		},
+	}
+
+	if err := conf.AddRoute(route); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mirrorFrontend, err := conf.GetFrontend(MirrorName("mirror_route", "service_b"))
+	if err != nil || mirrorFrontend.DefaultBackend != BackendName("mirror_route", "service_b") {
+		t.Fatalf("Failed to create mirror frontend")
+	}
+
+	if fe, _ := conf.GetFrontend("mirror_route"); fe.Mirror.UnixSock != mirrorFrontend.UnixSock || !conf.HasMirrors() {
+		t.Errorf("Failed to set mirror on the stable frontend")
+	}
+
+	if err := conf.DeleteRouteService("mirror_route", "service_b"); err == nil || err.Code != 400 {
+		t.Errorf("Deleting a mirrored service should fail with a 400")
+	}
+
+	if err := conf.UpdateRouteService("mirror_route", "service_b", &Service{Name: "service_b", Weight: 10}); err == nil || err.Code != 400 {
+		t.Errorf("Updating a mirrored service should fail with a 400")
+	}
+
+	if route, _ := conf.GetRoute("mirror_route"); len(route.Services) != 2 || !conf.FrontendExists(FrontendName("mirror_route", "service_b")) || !conf.BackendExists(BackendName("mirror_route", "service_b")) {
+		t.Errorf("Failed to leave the route untouched when refusing to delete a mirrored service")
+	}
+
+	if err := conf.DeleteRouteService("mirror_route", "service_a"); err != nil {
+		t.Errorf("Failed to delete a service that is not mirrored: %s", err.Error())
+	}
+
+	if err := conf.DeleteRoute("mirror_route"); err != nil || conf.FrontendExists(MirrorName("mirror_route", "service_b")) {
+		t.Errorf("Failed to delete mirror frontend")
+	}
+
+	wrongMirrors := []*Mirror{
+		&Mirror{Service: "service_b", Percentage: 0},
+		&Mirror{Service: "service_b", Percentage: 101},
+		&Mirror{Service: "non_existent_service", Percentage: 10},
+	}
+
+	for _, mirror := range wrongMirrors {
+		route.Mirror = mirror
+		if err := conf.AddRoute(route); err == nil {
+			t.Errorf("Adding should fail using a non-valid mirror: %v", mirror)
+		}
+	}
+}
+
+func TestConfiguration_SetServiceServerState(t *testing.T) {
+
+	conf := Config{WorkingDir: "/tmp"}
+	conf.InitializeConfig()
+
+	route := Route{
+		Name:     "state_route",
+		Port:     9033,
+		Protocol: "http",
+		Services: []*Service{
+			&Service{Name: "service_a", Weight: 100, Servers: []*Server{
+				&Server{Name: "server_a", Host: "192.168.2.2", Port: 8081},
+				&Server{Name: "server_b", Host: "192.168.2.2", Port: 8082, State: "maint"},
+			}},
+		},
+	}
+
+	if err := conf.AddRoute(route); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if srv, _ := conf.GetServer(BackendName("state_route", "service_a"), "server_b"); srv.State != "maint" {
+		t.Errorf("Failed to copy the server state to the backend")
+	}
+
+	if err := conf.SetServiceServerState("state_route", "service_a", "server_a", "drain"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	server, _ := conf.GetServiceServer("state_route", "service_a", "server_a")
+	srvDetail, _ := conf.GetServer(BackendName("state_route", "service_a"), "server_a")
+	if server.State != "drain" || srvDetail.State != "drain" {
+		t.Errorf("Failed to set the server state")
+	}
+
+	if err := conf.SetServerState(BackendName("state_route", "service_a"), "server_b", "ready"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if server, _ := conf.GetServiceServer("state_route", "service_a", "server_b"); server.State != "ready" {
+		t.Errorf("Failed to set the state of the service server through its backend")
+	}
+
+	if err := conf.SetServiceServerState("state_route", "service_a", "server_a", "sleeping"); err == nil || err.Code != 400 {
+		t.Errorf("Setting a non-valid server state should fail")
+	}
+
+	if err := conf.SetServiceServerState("state_route", "service_a", "non_existent_server", "maint"); err == nil || err.Code != 404 {
+		t.Errorf("Setting the state of a non-existent server should fail")
+	}
+}
@@ -1,22 +1,16 @@
 package haproxy
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"github.com/magneticio/vamp-router/tools"
 	"io/ioutil"
-	"net"
 	"os"
-	"os/exec"
-	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// returns an error if the file was already there
+// returns an error if the file was already there and holds a running process
 func (r *Runtime) SetPid(pidfile string) error {
 
 	//Create and empty pid file on the specified location, if not already there
@@ -25,7 +19,18 @@ func (r *Runtime) SetPid(pidfile string) error {
 		ioutil.WriteFile(pidfile, emptyPid, 0644)
 		return nil
 	}
-	return errors.New("file already there")
+
+	// a pid file left by processes that are gone is emptied, so the next reload does not signal
+	// whatever process reused their pids
+	pids, _ := readPids(pidfile)
+	for _, pid := range pids {
+		if pidAlive(pid) {
+			return errors.New("file already there")
+		}
+	}
+
+	ioutil.WriteFile(pidfile, []byte(""), 0644)
+	return nil
 }
 
 // Reload runtime with configuration
@@ -35,39 +40,100 @@ func (r *Runtime) Reload(c *Config) error {
 	if err != nil {
 		return err
 	}
+	oldPid := strings.Trim(string(pid), "\n")
+
+	// dump the server state of the running Haproxy, so the new process can load it. Without a running
+	// Haproxy any state file left is stale.
+	var state map[string]serverState
+	if len(oldPid) > 0 && len(c.StateFile) > 0 {
+		state, _ = r.saveServerState(c.StateFile)
+	} else if len(c.StateFile) > 0 {
+		os.Remove(c.StateFile)
+	}
+
+	start := time.Now()
+	seamless, err := r.process().Reload(r, c, oldPid)
+	if err != nil {
+		return err
+	}
 
-	/*  Setup all the command line parameters so we get an executable similar to
-	    /usr/local/bin/haproxy -f resources/haproxy_new.cfg -p resources/haproxy-private.pid -sf 1234
+	// a persistent connection still talks to the old process
+	r.socket().Close()
 
-	*/
-	arg0 := "-f"
-	arg1 := c.ConfigFile
-	arg2 := "-p"
-	arg3 := c.PidFile
-	arg4 := "-D"
-	arg5 := "-sf"
-	arg6 := strings.Trim(string(pid), "\n")
-	var cmd *exec.Cmd
+	r.reloads++
+	r.reloadTime += time.Since(start)
 
-	// fmt.Println(r.Binary + " " + arg0 + " " + arg1 + " " + arg2 + " " + arg3 + " " + arg4 + " " + arg5 + " " + arg6)
-	// If this is the first run, the PID value will be empty, otherwise it will be > 0
-	if len(arg6) > 0 {
-		cmd = exec.Command(r.Binary, arg0, arg1, arg2, arg3, arg4, arg5, arg6)
-	} else {
-		cmd = exec.Command(r.Binary, arg0, arg1, arg2, arg3, arg4)
+	newPid, _ := ioutil.ReadFile(c.PidFile)
+	r.LastReload = &ReloadReport{
+		Time:     start,
+		OldPid:   oldPid,
+		NewPid:   strings.Trim(string(newPid), "\n"),
+		Seamless: seamless,
+		Duration: time.Since(start).String(),
 	}
 
-	var out bytes.Buffer
-	cmd.Stdout = &out
+	if state != nil {
+		r.StateCheck = r.verifyServerState(state)
+	}
 
-	cmdErr := cmd.Run()
-	if cmdErr != nil {
-		return cmdErr
+	// Haproxy checks the config before it starts, so this one is known to be good
+	if config, err := ioutil.ReadFile(c.ConfigFile); err == nil {
+		ioutil.WriteFile(knownGoodConfig(c), config, 0644)
 	}
 
 	return nil
 }
 
+/*
+  Restarts Haproxy from the last known-good config after all its processes stopped. The known-good config is
+  copied over the config file first, as a master re-executes itself with the same config file on every reload.
+*/
+func (r *Runtime) Restart(c *Config) error {
+
+	if config, err := ioutil.ReadFile(knownGoodConfig(c)); err == nil {
+		if err := ioutil.WriteFile(c.ConfigFile, config, 0644); err != nil {
+			return err
+		}
+	}
+
+	if err := ioutil.WriteFile(c.PidFile, []byte(""), 0644); err != nil {
+		return err
+	}
+
+	r.socket().Close()
+	return r.process().Restart(r, c)
+}
+
+// Stops Haproxy when it is managed by the router, i.e. in master-worker mode.
+func (r *Runtime) Stop() error {
+	return r.process().Stop()
+}
+
+// Lists the Haproxy processes
+func (r *Runtime) Workers(c *Config) ([]Worker, error) {
+	return r.process().Workers(c)
+}
+
+// the stats socket defaults to a new connection per command
+func (r *Runtime) socket() *StatsSocket {
+	if r.Socket == nil {
+		return NewStatsSocket(r.SockFile)
+	}
+	return r.Socket
+}
+
+// the process manager defaults to the classic, daemonized Haproxy
+func (r *Runtime) process() ProcessManager {
+	if r.Process == nil {
+		return &ClassicProcess{}
+	}
+	return r.Process
+}
+
+func knownGoodConfig(c *Config) string {
+	return c.ConfigFile + ".good"
+}
+
 // Sets the weight of a backend
 func (r *Runtime) SetWeight(backend string, server string, weight int) (string, error) {
 
@@ -81,6 +147,58 @@ func (r *Runtime) SetWeight(backend string, server string, weight int) (string,
 
 }
 
+// Sets the address and port of a server
+func (r *Runtime) SetServerAddr(backend string, server string, host string, port int) (string, error) {
+
+	result, err := r.cmd("set server " + backend + "/" + server + " addr " + host + " port " + strconv.Itoa(port) + "\n")
+
+	if err != nil {
+		return "", err
+	} else {
+		return result, nil
+	}
+}
+
+// Sets the admin state of a server. State is one of "ready", "drain" or "maint"
+func (r *Runtime) SetServerState(backend string, server string, state string) (string, error) {
+
+	result, err := r.cmd("set server " + backend + "/" + server + " state " + state + "\n")
+
+	if err != nil {
+		return "", err
+	} else {
+		return result, nil
+	}
+}
+
+// Gets the operational status of a server as reported by the stats, i.e. UP, DOWN, DRAIN or MAINT
+func (r *Runtime) GetServerStatus(backend string, server string) (string, *Error) {
+
+	stats, err := r.GetStats("server")
+	if err != nil {
+		return "", &Error{500, errors.New("Error getting server stats")}
+	}
+
+	if stat, ok := stats[backend+":"+server]; ok {
+		return stat["status"], nil
+	}
+	return "", &Error{404, errors.New("no server found in stats")}
+}
+
+// gets the current sessions of a proxy and server as reported by the stats
+func (r *Runtime) GetSessions(pxname string, svname string) (int, error) {
+
+	stats, err := r.GetStats("all")
+	if err != nil {
+		return 0, err
+	}
+
+	if stat, ok := stats[pxname+":"+svname]; ok {
+		return strconv.Atoi(stat["scur"])
+	}
+	return 0, errors.New("no stats found for " + pxname + ":" + svname)
+}
+
 // Adds an ACL.
 // We need to match a frontend name to an id. This is somewhat awkard.
 // func (r *Runtime) SetAcl(frontend string, acl string, pattern string) (string, error) {
@@ -101,11 +219,12 @@ func (r *Runtime) GetInfo() (Info, *Error) {
 	if err != nil {
 		return Info, &Error{500, errors.New("Error getting info")}
 	} else {
-		result, err := tools.MultiLineToJson(result)
+		// marshalled from a map, so all values are escaped properly
+		result, err := json.Marshal(parseInfoLines(result))
 		if err != nil {
 			return Info, &Error{500, err}
 		} else {
-			err := json.Unmarshal([]byte(result), &Info)
+			err := json.Unmarshal(result, &Info)
 			if err != nil {
 				return Info, &Error{500, err}
 			} else {
@@ -116,11 +235,27 @@ func (r *Runtime) GetInfo() (Info, *Error) {
 
 }
 
+// Gets the typed info on the haproxy process
+func (r *Runtime) GetProcessInfo() (*ProcessInfo, *Error) {
+
+	result, err := r.cmd("show info\n")
+	if err != nil {
+		return nil, &Error{500, errors.New("Error getting info")}
+	}
+
+	info, err := ParseInfo(result)
+	if err != nil {
+		return nil, &Error{500, err}
+	}
+	return info, nil
+}
+
 /* get the basic stats in CSV format
 @parameter statsType takes the form of:
 - all
 - frontend
 - backend
+- server
 
 Returns a struct. This one is only used by the frontend API
 
@@ -129,35 +264,17 @@ Returns a struct. This one is only used by the frontend API
 func (r *Runtime) GetJsonStats(statsType string) ([]Stats, error) {
 
 	var Stats []Stats
-	var cmdString string
 
-	defer func() error {
-		if r := recover(); r != nil {
-			return errors.New("Cannot read from Haproxy socket")
-		}
-		return nil
-	}()
-
-	switch statsType {
-	case "all":
-		cmdString = "show stat -1\n"
-	case "backend":
-		cmdString = "show stat -1 2 -1\n"
-	case "frontend":
-		cmdString = "show stat -1 1 -1\n"
-	case "server":
-		cmdString = "show stat -1 4 -1\n"
-	}
-
-	result, err := r.cmd(cmdString)
+	rows, err := r.statsRows(statsType)
 	if err != nil {
 		return Stats, err
 	} else {
-		result, err := tools.CsvToJson(strings.Trim(removeStatsLines(result), "# "))
+		// marshalled from maps, so all values are escaped properly
+		result, err := json.Marshal(rows)
 		if err != nil {
 			return Stats, err
 		} else {
-			err := json.Unmarshal([]byte(result), &Stats)
+			err := json.Unmarshal(result, &Stats)
 			if err != nil {
 				return Stats, err
 			} else {
@@ -168,12 +285,37 @@ func (r *Runtime) GetJsonStats(statsType string) ([]Stats, error) {
 	}
 }
 
+/* get the typed stats
+
+@parameter statsType takes the form of:
+- all
+- frontend
+- backend
+- server
+
+*/
+
+func (r *Runtime) GetProxyStats(statsType string) ([]*ProxyStats, error) {
+
+	rows, err := r.statsRows(statsType)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := []*ProxyStats{}
+	for _, row := range rows {
+		stats = append(stats, newProxyStats(row))
+	}
+	return stats, nil
+}
+
 /* get the basic stats in CSV format
 
 @parameter statsType takes the form of:
 - all
 - frontend
 - backend
+- server
 
 returns a map of a map of strings with all metrics per proxy, i.e:
 
@@ -185,9 +327,24 @@ returns a map of a map of strings with all metrics per proxy, i.e:
 
 func (r *Runtime) GetStats(statsType string) (map[string]map[string]string, error) {
 
-	var cmdString string
 	m := make(map[string]map[string]string)
 
+	rows, err := r.statsRows(statsType)
+	if err != nil {
+		return m, err
+	}
+
+	for _, row := range rows {
+		m[row["pxname"]+":"+row["svname"]] = row
+	}
+	return m, nil
+}
+
+// gets the stats of a type as one map per proxy
+func (r *Runtime) statsRows(statsType string) ([]map[string]string, error) {
+
+	var cmdString string
+
 	switch statsType {
 	case "all":
 		cmdString = "show stat -1\n"
@@ -197,44 +354,48 @@ func (r *Runtime) GetStats(statsType string) (map[string]map[string]string, erro
 		cmdString = "show stat -1 1 -1\n"
 	case "server":
 		cmdString = "show stat -1 4 -1\n"
+	default:
+		return nil, errors.New("unknown stats type: " + statsType)
 	}
 
 	result, err := r.cmd(cmdString)
 	if err != nil {
-		return m, err
-	} else {
-
-		result, err := tools.CsvToMap(strings.Trim(removeStatsLines(result), "# "))
-		return result, err
+		return nil, err
 	}
+	return parseStatsCsv(result)
 }
 
 // Executes a arbitrary HAproxy command on the unix socket
 func (r *Runtime) cmd(cmd string) (string, error) {
+	return r.socket().Cmd(cmd)
+}
 
-	// connect to haproxy
-	conn, err_conn := net.Dial("unix", r.SockFile)
-	defer conn.Close()
-
-	if err_conn != nil {
-		return "", errors.New("Unable to connect to Haproxy socket")
-	} else {
-
-		fmt.Fprint(conn, cmd)
+// commands changing the running Haproxy reply with an empty line on success, any other reply is an error
+func commandError(reply string, err error) error {
 
-		response := ""
+	if err != nil {
+		return err
+	}
+	if reply = strings.TrimSpace(reply); len(reply) > 0 {
+		return errors.New(reply)
+	}
+	return nil
+}
 
-		scanner := bufio.NewScanner(conn)
-		for scanner.Scan() {
-			response += (scanner.Text() + "\n")
-		}
-		if err := scanner.Err(); err != nil {
-			return "", err
-		} else {
-			return response, nil
-		}
+/*
+  Haproxy 1.8+ confirms a new server address, i.e. "IP changed from '127.0.0.1' to '192.168.2.2', port changed
+  from '0' to '8081' by 'stats socket command'", or replies that there was no need to change it. Any other
+  reply is an error.
+*/
+func addrChangeError(reply string, err error) error {
 
+	if err != nil {
+		return err
 	}
+	if reply = strings.TrimSpace(reply); strings.Contains(reply, "changed from") || strings.HasPrefix(reply, "no need to change") {
+		return nil
+	}
+	return commandError(reply, nil)
 }
 
 func (r *Runtime) Reset() *Error {
@@ -244,9 +405,3 @@ func (r *Runtime) Reset() *Error {
 	}
 	return nil
 }
-
-func removeStatsLines(in string) string {
-	rx := regexp.MustCompile("stats[,].*")
-	res := rx.ReplaceAllString(in, "")
-	return res
-}
@@ -0,0 +1,49 @@
+package haproxy
+
+import (
+	"time"
+)
+
+/*
+  A RuntimeProvider controls a running Haproxy: reloading it with a new config, reading its stats and info and
+  changing weights and server states at runtime. The Runtime implements it against a real Haproxy binary and
+  stats socket, the FakeRuntime against an in-process fake of the stats socket for tests.
+*/
+type RuntimeProvider interface {
+	Reload(c *Config) error
+	GetInfo() (Info, *Error)
+	GetStats(statsType string) (map[string]map[string]string, error)
+	GetJsonStats(statsType string) ([]Stats, error)
+	GetProxyStats(statsType string) ([]*ProxyStats, error)
+	GetProcessInfo() (*ProcessInfo, *Error)
+	GetServerStatus(backend string, server string) (string, *Error)
+	GetSessions(pxname string, svname string) (int, error)
+	SetWeight(backend string, server string, weight int) (string, error)
+	SetServerState(backend string, server string, state string) (string, error)
+	SetServerAddr(backend string, server string, host string, port int) (string, error)
+	Reset() *Error
+	Status(c *Config) RuntimeStatus
+}
+
+// The status of the Haproxy processes behind a RuntimeProvider, as reported on /v1/info
+type RuntimeStatus struct {
+	ServerState *ServerStateCheck
+	Reload      *ReloadReport
+	Process     *ProcessStatus
+	Workers     []Worker
+	Reloads     int
+	ReloadTime  time.Duration
+}
+
+func (r *Runtime) Status(c *Config) RuntimeStatus {
+
+	status := RuntimeStatus{ServerState: r.StateCheck, Reload: r.LastReload, Reloads: r.reloads, ReloadTime: r.reloadTime}
+
+	if r.Supervisor != nil {
+		process := r.Supervisor.Status()
+		status.Process = &process
+	}
+
+	status.Workers, _ = r.Workers(c)
+	return status
+}
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strconv"
 	"testing"
 )
 
@@ -25,8 +26,8 @@ func TestRuntime_SetNewPid(t *testing.T) {
 
 func TestRuntime_UseExistingPid(t *testing.T) {
 
-	//create a pid file
-	emptyPid := []byte("12356")
+	//create a pid file holding a running process
+	emptyPid := []byte(strconv.Itoa(os.Getpid()))
 	ioutil.WriteFile(PID_FILE, emptyPid, 0644)
 	defer os.Remove(PID_FILE)
 
@@ -36,6 +37,24 @@ func TestRuntime_UseExistingPid(t *testing.T) {
 
 }
 
+func TestRuntime_StalePid(t *testing.T) {
+
+	//create a pid file holding a process that is gone
+	cmd := exec.Command("true")
+	cmd.Run()
+	stalePid := []byte(strconv.Itoa(cmd.ProcessState.Pid()))
+	ioutil.WriteFile(PID_FILE, stalePid, 0644)
+	defer os.Remove(PID_FILE)
+
+	if err := haRuntime.SetPid(PID_FILE); err != nil {
+		t.Fatalf("err: Failed to reset stale pid file")
+	}
+
+	if pid, _ := ioutil.ReadFile(PID_FILE); len(pid) != 0 {
+		t.Errorf("err: Stale pid file was not emptied")
+	}
+}
+
 // all tests againt a running Haproxy are for now lumped together. TODO: split it up
 func TestRuntime_HaproxyFunctions(t *testing.T) {
 
@@ -90,8 +109,8 @@ func TestRuntime_HaproxyFunctions(t *testing.T) {
 		t.Error("failed to update weight on server")
 	}
 
-	if result, _ := haRuntime.SetWeight("test_be_1", "no_such_server", 50); result != "No such server.\n\n" {
-		t.Error("should return error when setting weight on non existent server: " + result)
+	if _, err := haRuntime.SetWeight("test_be_1", "no_such_server", 50); err != ErrNoSuchServer {
+		t.Error("should return error when setting weight on non existent server")
 	}
 
 	// acl function not yet done
@@ -118,3 +137,74 @@ func TestRuntime_HaproxyFunctions(t *testing.T) {
 func DestroyHaproxy() {
 	_ = exec.Command("killall", "haproxy").Run()
 }
+
+func TestRuntime_ParseServerState(t *testing.T) {
+
+	before := `1
+# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight
+3 test_be_1 1 server_1 192.168.2.2 0 0 100 100
+3 test_be_1 2 server_2 192.168.2.3 2 1 100 100
+4 test_be_2 1 server_1 192.168.2.4 2 0 50 100
+
+`
+	after := `1
+# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight
+3 test_be_1 1 server_1 192.168.2.2 2 0 100 100
+3 test_be_1 2 server_2 192.168.2.3 2 1 100 100
+4 test_be_2 1 server_1 192.168.2.4 2 0 100 100
+
+`
+	beforeState, err := parseServerState(before)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(beforeState) != 3 || beforeState["test_be_1/server_2"].AdminState != "1" || beforeState["test_be_1/server_1"].OpState != "0" {
+		t.Fatalf("Failed to parse server state: %v", beforeState)
+	}
+
+	afterState, _ := parseServerState(after)
+	if mismatches := compareServerState(beforeState, afterState); len(mismatches) != 1 || mismatches[0] != "test_be_1/server_1" {
+		t.Errorf("Failed to compare server state: %v", mismatches)
+	}
+
+	if _, err := parseServerState("1\n3 test_be_1 1 server_1\n"); err == nil {
+		t.Errorf("Parsing server state without a header should fail")
+	}
+}
+
+func TestRuntime_SeamlessReload(t *testing.T) {
+
+	// a fake Haproxy binary that records its arguments and writes a new pid
+	binary := "/tmp/vamp_fake_haproxy.sh"
+	script := "#!/bin/sh\necho \"$@\" > /tmp/vamp_fake_haproxy.args\necho 4321 > /tmp/vamp_reload_test.pid\n"
+	if err := ioutil.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(binary)
+	defer os.Remove("/tmp/vamp_fake_haproxy.args")
+
+	ioutil.WriteFile("/tmp/vamp_reload_test.pid", []byte("1234\n"), 0644)
+	defer os.Remove("/tmp/vamp_reload_test.pid")
+
+	runtime := Runtime{Binary: binary, SockFile: "/tmp/vamp_reload_test.sock"}
+	conf := Config{
+		ConfigFile:     "/tmp/vamp_reload_test.cfg",
+		PidFile:        "/tmp/vamp_reload_test.pid",
+		SockFile:       "/tmp/vamp_reload_test.sock",
+		SeamlessReload: true,
+	}
+
+	if err := runtime.Reload(&conf); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	args, _ := ioutil.ReadFile("/tmp/vamp_fake_haproxy.args")
+	if string(args) != "-f /tmp/vamp_reload_test.cfg -p /tmp/vamp_reload_test.pid -D -x /tmp/vamp_reload_test.sock -sf 1234\n" {
+		t.Errorf("Failed to pass the listening sockets on a seamless reload: %s", args)
+	}
+
+	if report := runtime.LastReload; report == nil || report.OldPid != "1234" || report.NewPid != "4321" || !report.Seamless {
+		t.Errorf("Failed to report on the reload: %v", report)
+	}
+}
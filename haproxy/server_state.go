@@ -0,0 +1,126 @@
+package haproxy
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+  The server state of a running Haproxy is dumped to the server-state-file before every reload. The new
+  process loads it, so servers marked down by health checks stay down and runtime weight and admin state
+  changes are not lost. After the reload, the state of the new process is compared to the dumped state.
+*/
+type ServerStateCheck struct {
+	Time       time.Time `json:"time"`
+	Servers    int       `json:"servers"`
+	Mismatches []string  `json:"mismatches"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// the state of one server as found in the output of "show servers state"
+type serverState struct {
+	OpState    string
+	AdminState string
+}
+
+// dumps the server state of the running Haproxy to a file and returns the parsed state
+func (r *Runtime) saveServerState(file string) (map[string]serverState, error) {
+
+	result, err := r.cmd("show servers state\n")
+	if err != nil {
+		// never leave a stale state file behind, it would be loaded by the next process
+		os.Remove(file)
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(file, []byte(result), 0644); err != nil {
+		os.Remove(file)
+		return nil, err
+	}
+
+	return parseServerState(result)
+}
+
+// compares the server state of the running Haproxy to a previously dumped state
+func (r *Runtime) verifyServerState(before map[string]serverState) *ServerStateCheck {
+
+	check := &ServerStateCheck{Time: time.Now(), Mismatches: []string{}}
+
+	result, err := r.cmd("show servers state\n")
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+
+	after, err := parseServerState(result)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+
+	check.Servers = len(after)
+	check.Mismatches = compareServerState(before, after)
+	return check
+}
+
+/*
+  Parses the output of "show servers state" to a map keyed by "backend/server". The columns are looked up
+  by the names in the header line, as they differ between Haproxy versions, i.e:
+
+  1
+  # be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight ...
+  3 test_be_1 1 server_1 192.168.2.2 2 0 100 100 ...
+*/
+func parseServerState(dump string) (map[string]serverState, error) {
+
+	m := make(map[string]serverState)
+	columns := make(map[string]int)
+
+	for _, line := range strings.Split(dump, "\n") {
+
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) < 2:
+			continue
+		case fields[0] == "#":
+			for i, name := range fields[1:] {
+				columns[name] = i
+			}
+		default:
+			if len(columns) == 0 {
+				return m, errors.New("no header found in server state")
+			}
+			get := func(name string) string {
+				if i, ok := columns[name]; ok && i < len(fields) {
+					return fields[i]
+				}
+				return ""
+			}
+			m[get("be_name")+"/"+get("srv_name")] = serverState{
+				OpState:    get("srv_op_state"),
+				AdminState: get("srv_admin_state"),
+			}
+		}
+	}
+	return m, nil
+}
+
+// returns the servers present before and after a reload whose operational or admin state changed. Weights
+// are not compared, as a weight changed in the config rightfully overrides the dumped one.
+func compareServerState(before map[string]serverState, after map[string]serverState) []string {
+
+	mismatches := []string{}
+	for name, state := range before {
+		if newState, ok := after[name]; ok {
+			if newState.OpState != state.OpState || newState.AdminState != state.AdminState {
+				mismatches = append(mismatches, name)
+			}
+		}
+	}
+	sort.Strings(mismatches)
+	return mismatches
+}
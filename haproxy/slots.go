@@ -0,0 +1,233 @@
+package haproxy
+
+import (
+	"errors"
+	"strconv"
+)
+
+const (
+	SLOT_PREFIX = "slot_"
+	SLOT_HOST   = "127.0.0.1"
+	SLOT_PORT   = 80
+)
+
+/*
+  Slots allow scaling the servers of a service without reloading Haproxy. A service with slots gets a pool of
+  pre-provisioned, disabled servers in its backend. Adding a server fills a free slot by setting its address
+  and state on the running Haproxy, deleting a server puts its slot back in maintenance. Only when the pool is
+  exhausted, it grows by the number of slots of the service and Haproxy needs a reload.
+
+  Slots are rendered as regular server lines instead of a server-template, so filled slots keep their address
+  over a reload. Setting the address of a server at runtime requires Haproxy 1.7+.
+
+      service_a
+      +----------------------------------------------+
+      | slot_1 -> server_1 (192.168.2.2:8081)  ready |
+      | slot_2 -> server_2 (192.168.2.3:8081)  ready |
+      | slot_3 -> free     (127.0.0.1:80)      maint |
+      +----------------------------------------------+
+*/
+
+// helper function to create the name of the nth slot of a backend
+func SlotName(n int) string {
+	return SLOT_PREFIX + strconv.Itoa(n)
+}
+
+/*
+  Resolves the name of a server of a service to the name Haproxy knows it by. For services with slots this is
+  the name of the slot filled by the server, for other services it is the server name itself.
+*/
+func (c *Config) BackendServerName(routeName string, serviceName string, serverName string) string {
+
+	servers, _ := c.GetServers(BackendName(routeName, serviceName))
+	for _, srv := range servers {
+		if srv.Slot && srv.SlotServer == serverName {
+			return srv.Name
+		}
+	}
+	return serverName
+}
+
+// turns the servers of a new service backend into filled slots and adds the free slots
+func (c *Config) provisionSlots(backend *Backend, count int) {
+
+	for i, srv := range backend.Servers {
+		srv.SlotServer = srv.Name
+		srv.Name = SlotName(i + 1)
+		srv.Slot = true
+	}
+	c.addFreeSlots(backend, count)
+}
+
+func (c *Config) addFreeSlots(backend *Backend, count int) {
+
+	n := len(backend.Servers)
+	for i := 1; i <= count; i++ {
+		slot := c.serverFactory(SlotName(n+i), DEFAULT_WEIGHT, SLOT_HOST, SLOT_PORT, false)
+		slot.Slot = true
+		slot.State = "maint"
+		backend.Servers = append(backend.Servers, slot)
+	}
+}
+
+/*
+  Maps the slots of all backends, keyed as "<backend>:<slot>" like the stats, to the servers filling them.
+  Free slots map to an empty name.
+*/
+func (c *Config) SlotServers() map[string]string {
+
+	slots := make(map[string]string)
+	for _, backend := range c.Backends {
+		for _, srv := range backend.Servers {
+			if srv.Slot {
+				slots[backend.Name+":"+srv.Name] = srv.SlotServer
+			}
+		}
+	}
+	return slots
+}
+
+func nextFreeSlot(backend *Backend) *ServerDetail {
+
+	for _, srv := range backend.Servers {
+		if srv.Slot && len(srv.SlotServer) == 0 {
+			return srv
+		}
+	}
+	return nil
+}
+
+/*
+  Fills the first free slot of a backend with a server, weighted like the servers of the service. When no
+  slot is free, the pool grows first.
+*/
+func (c *Config) fillSlot(backendName string, server *Server, weight int, grow int) *Error {
+
+	backend, err := c.GetBackend(backendName)
+	if err != nil {
+		return err
+	}
+
+	slot := nextFreeSlot(backend)
+	if slot == nil {
+		c.addFreeSlots(backend, grow)
+		slot = nextFreeSlot(backend)
+	}
+
+	slot.SlotServer = server.Name
+	slot.Host = server.Host
+	slot.Port = server.Port
+	slot.Weight = weight
+	slot.Backup = server.Backup
+	slot.State = server.State
+	return nil
+}
+
+// puts the slot filled by a server back in the pool
+func (c *Config) releaseSlot(backendName string, serverName string) *Error {
+
+	servers, err := c.GetServers(backendName)
+	if err != nil {
+		return err
+	}
+
+	for _, srv := range servers {
+		if srv.Slot && srv.SlotServer == serverName {
+			srv.SlotServer = ""
+			srv.Host = SLOT_HOST
+			srv.Port = SLOT_PORT
+			srv.Weight = DEFAULT_WEIGHT
+			srv.Backup = false
+			srv.State = "maint"
+			return nil
+		}
+	}
+	return &Error{404, errors.New("no slot found for server: " + serverName)}
+}
+
+/*
+  Adds a server to a service and applies it to the running Haproxy when the service has a free slot.
+  Returns true when Haproxy still needs a reload, i.e. the service has no slots, the pool was exhausted, the
+  server is a backup or the slot could not be filled at runtime.
+*/
+func (c *Config) AddServiceServerLive(r RuntimeProvider, routeName string, serviceName string, server *Server) (bool, *Error) {
+
+	service, err := c.GetRouteService(routeName, serviceName)
+	if err != nil {
+		return false, err
+	}
+
+	if service.Slots == 0 {
+		return true, c.AddServiceServer(routeName, serviceName, server)
+	}
+
+	if c.ServerExists(routeName, serviceName, server.Name) {
+		return false, nil
+	}
+
+	backend, err := c.GetBackend(BackendName(routeName, serviceName))
+	if err != nil {
+		return false, err
+	}
+
+	// a nil slot means the pool grows, new slots only exist after a reload
+	slot := nextFreeSlot(backend)
+
+	if err := c.AddServiceServer(routeName, serviceName, server); err != nil {
+		return false, err
+	}
+
+	// the backup flag of a server can not be changed at runtime
+	if slot == nil || slot.Backup {
+		return true, nil
+	}
+
+	if err := addrChangeError(r.SetServerAddr(backend.Name, slot.Name, slot.Host, slot.Port)); err != nil {
+		return true, nil
+	}
+
+	if err := commandError(r.SetWeight(backend.Name, slot.Name, slot.Weight)); err != nil {
+		return true, nil
+	}
+
+	state := slot.State
+	if len(state) == 0 {
+		state = "ready"
+	}
+
+	if err := commandError(r.SetServerState(backend.Name, slot.Name, state)); err != nil {
+		return true, nil
+	}
+	return false, nil
+}
+
+/*
+  Deletes a server from a service and frees its slot on the running Haproxy. Returns true when Haproxy still
+  needs a reload, i.e. the service has no slots or the slot could not be freed at runtime.
+*/
+func (c *Config) DeleteServiceServerLive(r RuntimeProvider, routeName string, serviceName string, serverName string) (bool, *Error) {
+
+	service, err := c.GetRouteService(routeName, serviceName)
+	if err != nil {
+		return false, err
+	}
+
+	if service.Slots == 0 {
+		return true, c.DeleteServiceServer(routeName, serviceName, serverName)
+	}
+
+	if !c.ServerExists(routeName, serviceName, serverName) {
+		return false, nil
+	}
+
+	slotName := c.BackendServerName(routeName, serviceName, serverName)
+
+	if err := c.DeleteServiceServer(routeName, serviceName, serverName); err != nil {
+		return false, err
+	}
+
+	if err := commandError(r.SetServerState(BackendName(routeName, serviceName), slotName, "maint")); err != nil {
+		return true, nil
+	}
+	return false, nil
+}
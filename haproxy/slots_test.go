@@ -0,0 +1,112 @@
+package haproxy
+
+import (
+	"testing"
+)
+
+func TestConfiguration_ServiceSlots(t *testing.T) {
+
+	conf := Config{WorkingDir: "/tmp"}
+	conf.InitializeConfig()
+
+	route := Route{
+		Name:     "slots_route",
+		Port:     9035,
+		Protocol: "http",
+		Services: []*Service{
+			&Service{Name: "service_a", Weight: 100, Slots: 2, Servers: []*Server{
+				&Server{Name: "server_a", Host: "192.168.2.2", Port: 8081},
+			}},
+		},
+	}
+
+	if err := conf.AddRoute(route); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	backendName := BackendName("slots_route", "service_a")
+	servers, _ := conf.GetServers(backendName)
+	if len(servers) != 3 || servers[0].SlotServer != "server_a" || servers[1].State != "maint" || servers[2].Name != SlotName(3) {
+		t.Fatalf("Failed to provision slots")
+	}
+
+	if conf.BackendServerName("slots_route", "service_a", "server_a") != SlotName(1) {
+		t.Errorf("Failed to resolve the slot of a server")
+	}
+
+	conf.AddServiceServer("slots_route", "service_a", &Server{Name: "server_b", Host: "192.168.2.3", Port: 8081})
+	conf.AddServiceServer("slots_route", "service_a", &Server{Name: "server_c", Host: "192.168.2.4", Port: 8081})
+
+	servers, _ = conf.GetServers(backendName)
+	if servers[1].SlotServer != "server_b" || servers[1].Host != "192.168.2.3" || servers[1].State != "" {
+		t.Errorf("Failed to fill a free slot")
+	}
+
+	conf.AddServiceServer("slots_route", "service_a", &Server{Name: "server_d", Host: "192.168.2.5", Port: 8081})
+
+	servers, _ = conf.GetServers(backendName)
+	if len(servers) != 5 || servers[3].SlotServer != "server_d" || servers[4].SlotServer != "" {
+		t.Errorf("Failed to grow the pool of slots when exhausted")
+	}
+
+	if err := conf.DeleteServiceServer("slots_route", "service_a", "server_b"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	servers, _ = conf.GetServers(backendName)
+	if len(servers) != 5 || servers[1].SlotServer != "" || servers[1].State != "maint" || conf.ServerExists("slots_route", "service_a", "server_b") {
+		t.Errorf("Failed to release the slot of a deleted server")
+	}
+}
+
+func TestConfiguration_ServiceSlotsLive(t *testing.T) {
+
+	conf := Config{WorkingDir: "/tmp"}
+	conf.InitializeConfig()
+
+	route := Route{
+		Name:     "live_slots_route",
+		Port:     9036,
+		Protocol: "http",
+		Services: []*Service{
+			&Service{Name: "service_a", Weight: 50, Slots: 1},
+			&Service{Name: "service_b", Weight: 0},
+		},
+	}
+
+	if err := conf.AddRoute(route); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	runtime := newFakeRuntime(t, "/tmp/vamp_slots_test.sock")
+	defer runtime.Close()
+	runtime.Reload(&conf)
+
+	if reload, err := conf.AddServiceServerLive(runtime, "live_slots_route", "service_a", &Server{Name: "server_a", Host: "192.168.2.2", Port: 8081}); err != nil || reload {
+		t.Errorf("Adding a server to a free slot should not need a reload")
+	}
+
+	if status, _ := runtime.GetServerStatus(BackendName("live_slots_route", "service_a"), SlotName(1)); status != "UP" {
+		t.Errorf("Failed to put a filled slot in ready state, got %s", status)
+	}
+
+	if stats, _ := runtime.GetStats("server"); stats[BackendName("live_slots_route", "service_a")+":"+SlotName(1)]["weight"] != "50" {
+		t.Errorf("Failed to set the weight of the service on a filled slot")
+	}
+
+	if reload, err := conf.AddServiceServerLive(runtime, "live_slots_route", "service_a", &Server{Name: "server_b", Host: "192.168.2.3", Port: 8081}); err != nil || !reload {
+		t.Errorf("Adding a server to an exhausted pool should need a reload")
+	}
+
+	if reload, err := conf.DeleteServiceServerLive(runtime, "live_slots_route", "service_a", "server_a"); err != nil || reload {
+		t.Errorf("Deleting a server from a slot should not need a reload")
+	}
+
+	if reload, err := conf.AddServiceServerLive(runtime, "live_slots_route", "service_a", &Server{Name: "server_d", Host: "192.168.2.5", Port: 8081, Backup: true}); err != nil || !reload {
+		t.Errorf("Adding a backup server to a free slot should need a reload")
+	}
+
+	if reload, err := conf.AddServiceServerLive(runtime, "live_slots_route", "service_b", &Server{Name: "server_c", Host: "192.168.2.4", Port: 8081}); err != nil || !reload {
+		t.Errorf("Adding a server to a service without slots should need a reload")
+	}
+}
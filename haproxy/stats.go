@@ -0,0 +1,316 @@
+package haproxy
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the type of a proxy in the stats, as reported in the "type" column
+type ProxyType string
+
+const (
+	FRONTEND_TYPE ProxyType = "frontend"
+	BACKEND_TYPE  ProxyType = "backend"
+	SERVER_TYPE   ProxyType = "server"
+	LISTENER_TYPE ProxyType = "listener"
+)
+
+// the status of a proxy in the stats. Transitional states like "UP 1/3" are reported as UP, with the
+// transition kept separately.
+type ProxyStatus string
+
+const (
+	STATUS_UP       ProxyStatus = "UP"
+	STATUS_DOWN     ProxyStatus = "DOWN"
+	STATUS_OPEN     ProxyStatus = "OPEN"
+	STATUS_FULL     ProxyStatus = "FULL"
+	STATUS_STOP     ProxyStatus = "STOP"
+	STATUS_NOLB     ProxyStatus = "NOLB"
+	STATUS_DRAIN    ProxyStatus = "DRAIN"
+	STATUS_MAINT    ProxyStatus = "MAINT"
+	STATUS_NO_CHECK ProxyStatus = "no check"
+)
+
+// a duration that is marshalled to JSON like "12ms"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+/*
+  The typed stats of one proxy, i.e. a frontend, backend or server, parsed from "show stat". Every integer
+  column is also kept in Counters by its Haproxy name, so columns added by newer Haproxy versions are not lost.
+  Columns that are neither mapped nor integers end up in Fields.
+*/
+type ProxyStats struct {
+	Proxy      string            `json:"proxy"`
+	Server     string            `json:"server"`
+	Type       ProxyType         `json:"type"`
+	Status     ProxyStatus       `json:"status,omitempty"`
+	Transition string            `json:"transition,omitempty"`
+	Weight     int64             `json:"weight"`
+	Sessions   SessionStats      `json:"sessions"`
+	Queue      QueueStats        `json:"queue"`
+	Bytes      ByteStats         `json:"bytes"`
+	Requests   RequestStats      `json:"requests"`
+	Responses  ResponseStats     `json:"responses"`
+	Errors     ErrorStats        `json:"errors"`
+	Timings    TimingStats       `json:"timings"`
+	Counters   map[string]int64  `json:"counters"`
+	Fields     map[string]string `json:"fields,omitempty"`
+}
+
+type SessionStats struct {
+	Current int64 `json:"current"`
+	Max     int64 `json:"max"`
+	Limit   int64 `json:"limit"`
+	Total   int64 `json:"total"`
+	Rate    int64 `json:"rate"`
+	RateMax int64 `json:"rateMax"`
+}
+
+type QueueStats struct {
+	Current int64 `json:"current"`
+	Max     int64 `json:"max"`
+	Limit   int64 `json:"limit"`
+}
+
+type ByteStats struct {
+	In  int64 `json:"in"`
+	Out int64 `json:"out"`
+}
+
+type RequestStats struct {
+	Rate    int64 `json:"rate"`
+	RateMax int64 `json:"rateMax"`
+	Total   int64 `json:"total"`
+}
+
+type ResponseStats struct {
+	Hrsp1xx   int64 `json:"1xx"`
+	Hrsp2xx   int64 `json:"2xx"`
+	Hrsp3xx   int64 `json:"3xx"`
+	Hrsp4xx   int64 `json:"4xx"`
+	Hrsp5xx   int64 `json:"5xx"`
+	HrspOther int64 `json:"other"`
+}
+
+type ErrorStats struct {
+	Request    int64 `json:"request"`
+	Connection int64 `json:"connection"`
+	Response   int64 `json:"response"`
+}
+
+// the average times of the last 1024 requests
+type TimingStats struct {
+	Queue    Duration `json:"queue"`
+	Connect  Duration `json:"connect"`
+	Response Duration `json:"response"`
+	Total    Duration `json:"total"`
+}
+
+// The typed info of the Haproxy process, parsed from "show info"
+type ProcessInfo struct {
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	ReleaseDate string            `json:"releaseDate"`
+	Pid         int64             `json:"pid"`
+	Uptime      Duration          `json:"uptime"`
+	Counters    map[string]int64  `json:"counters"`
+	Fields      map[string]string `json:"fields,omitempty"`
+}
+
+// the columns mapped to typed fields of ProxyStats, they are not repeated in Fields
+var mappedStatsColumns = map[string]bool{"pxname": true, "svname": true, "type": true, "status": true}
+
+/*
+  Parses the CSV output of "show stat" to one map per proxy, keyed by column name. Lines of the internal
+  stats proxy are skipped, as is the trailing empty column Haproxy adds to every line.
+*/
+func parseStatsCsv(output string) ([]map[string]string, error) {
+
+	rows := []map[string]string{}
+
+	reader := csv.NewReader(strings.NewReader(strings.TrimLeft(output, "# ")))
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return rows, nil
+	} else if err != nil {
+		return rows, err
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return rows, err
+		}
+
+		row := make(map[string]string)
+		for i, column := range header {
+			if len(column) > 0 && i < len(record) {
+				row[column] = record[i]
+			}
+		}
+
+		if len(row["pxname"]) == 0 || row["pxname"] == "stats" {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parses the CSV output of "show stat" to typed stats
+func ParseStats(output string) ([]*ProxyStats, error) {
+
+	rows, err := parseStatsCsv(output)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := []*ProxyStats{}
+	for _, row := range rows {
+		stats = append(stats, newProxyStats(row))
+	}
+	return stats, nil
+}
+
+func newProxyStats(row map[string]string) *ProxyStats {
+
+	s := &ProxyStats{
+		Proxy:    row["pxname"],
+		Server:   row["svname"],
+		Type:     proxyType(row["type"], row["svname"]),
+		Counters: make(map[string]int64),
+		Fields:   make(map[string]string),
+	}
+
+	s.Status, s.Transition = proxyStatus(row["status"])
+
+	for column, value := range row {
+		if mappedStatsColumns[column] || len(value) == 0 {
+			continue
+		}
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			s.Counters[column] = n
+		} else {
+			s.Fields[column] = value
+		}
+	}
+
+	c := s.Counters
+	s.Weight = c["weight"]
+	s.Sessions = SessionStats{c["scur"], c["smax"], c["slim"], c["stot"], c["rate"], c["rate_max"]}
+	s.Queue = QueueStats{c["qcur"], c["qmax"], c["qlimit"]}
+	s.Bytes = ByteStats{c["bin"], c["bout"]}
+	s.Requests = RequestStats{c["req_rate"], c["req_rate_max"], c["req_tot"]}
+	s.Responses = ResponseStats{c["hrsp_1xx"], c["hrsp_2xx"], c["hrsp_3xx"], c["hrsp_4xx"], c["hrsp_5xx"], c["hrsp_other"]}
+	s.Errors = ErrorStats{c["ereq"], c["econ"], c["eresp"]}
+	s.Timings = TimingStats{millis(c["qtime"]), millis(c["ctime"]), millis(c["rtime"]), millis(c["ttime"])}
+	return s
+}
+
+// gets the raw value of a column that is not mapped to a typed field, i.e. "check_code"
+func (s *ProxyStats) Value(column string) string {
+
+	if n, ok := s.Counters[column]; ok {
+		return strconv.FormatInt(n, 10)
+	}
+	return s.Fields[column]
+}
+
+// maps the numeric "type" column, or the svname for Haproxy versions without it, to a ProxyType
+func proxyType(column string, svname string) ProxyType {
+
+	switch column {
+	case "0":
+		return FRONTEND_TYPE
+	case "1":
+		return BACKEND_TYPE
+	case "2":
+		return SERVER_TYPE
+	case "3":
+		return LISTENER_TYPE
+	}
+
+	switch svname {
+	case "FRONTEND":
+		return FRONTEND_TYPE
+	case "BACKEND":
+		return BACKEND_TYPE
+	}
+	return SERVER_TYPE
+}
+
+// splits a status like "UP 1/3" or "MAINT(via)" into the status and its transition or reason
+func proxyStatus(status string) (ProxyStatus, string) {
+
+	if status == string(STATUS_NO_CHECK) {
+		return STATUS_NO_CHECK, ""
+	}
+
+	if i := strings.IndexAny(status, " ("); i > 0 {
+		return ProxyStatus(status[:i]), strings.Trim(status[i:], " ()")
+	}
+	return ProxyStatus(status), ""
+}
+
+func millis(ms int64) Duration {
+	return Duration(time.Duration(ms) * time.Millisecond)
+}
+
+// parses the "Key: value" lines of "show info" to a map
+func parseInfoLines(output string) map[string]string {
+
+	m := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) == 2 && len(strings.TrimSpace(kv[0])) > 0 {
+			m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return m
+}
+
+// parses the output of "show info" to a typed ProcessInfo
+func ParseInfo(output string) (*ProcessInfo, error) {
+
+	lines := parseInfoLines(output)
+	if len(lines) == 0 {
+		return nil, errors.New("no info found")
+	}
+
+	info := &ProcessInfo{
+		Name:        lines["Name"],
+		Version:     lines["Version"],
+		ReleaseDate: lines["Release_date"],
+		Counters:    make(map[string]int64),
+		Fields:      make(map[string]string),
+	}
+
+	for key, value := range lines {
+		switch key {
+		case "Name", "Version", "Release_date":
+			continue
+		}
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			info.Counters[key] = n
+		} else {
+			info.Fields[key] = value
+		}
+	}
+
+	info.Pid = info.Counters["Pid"]
+	info.Uptime = Duration(time.Duration(info.Counters["Uptime_sec"]) * time.Second)
+	return info, nil
+}
@@ -0,0 +1,193 @@
+package haproxy
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errors replied by Haproxy to commands on the stats socket
+var (
+	ErrNoSuchServer     = errors.New("No such server.")
+	ErrNoSuchBackend    = errors.New("No such backend.")
+	ErrPermissionDenied = errors.New("Permission denied.")
+)
+
+/*
+  The StatsSocket sends commands to the stats socket of Haproxy, or to its master CLI. Every command gets a
+  deadline and failing connections are retried a bounded number of times.
+
+  By default every command opens a new connection, which Haproxy closes after replying. A persistent socket
+  keeps one connection open in interactive mode ("prompt"), which saves a connect per command when polling
+  stats at a high frequency. Haproxy replies with a "> " prompt after every command instead of closing.
+  Commands on a persistent socket are serialized.
+*/
+type StatsSocket struct {
+	Path       string
+	Timeout    time.Duration
+	Retries    int
+	RetryDelay time.Duration
+	Persistent bool
+	conn       net.Conn
+	mutex      sync.Mutex
+}
+
+// the prompt Haproxy ends its replies with in interactive mode
+const socketPrompt = "\n> "
+
+func NewStatsSocket(path string) *StatsSocket {
+	return &StatsSocket{
+		Path:       path,
+		Timeout:    5 * time.Second,
+		Retries:    2,
+		RetryDelay: 100 * time.Millisecond,
+	}
+}
+
+/*
+  Executes a command and returns the reply. Replies that Haproxy uses to reject a command are returned as
+  ErrNoSuchServer, ErrNoSuchBackend or ErrPermissionDenied. These are not retried.
+*/
+func (s *StatsSocket) Cmd(cmd string) (string, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !strings.HasSuffix(cmd, "\n") {
+		cmd += "\n"
+	}
+
+	var reply string
+	var err error
+
+	for attempt := 0; attempt <= s.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(s.RetryDelay)
+		}
+
+		if s.Persistent {
+			reply, err = s.interactiveCmd(cmd)
+		} else {
+			reply, err = s.singleCmd(cmd)
+		}
+
+		if err == nil {
+			return reply, replyError(reply)
+		}
+	}
+	return "", err
+}
+
+// closes the persistent connection, the next command opens a new one
+func (s *StatsSocket) Close() error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closeConn()
+}
+
+func (s *StatsSocket) closeConn() error {
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func (s *StatsSocket) dial() (net.Conn, error) {
+
+	conn, err := net.DialTimeout("unix", s.Path, s.Timeout)
+	if err != nil {
+		return nil, errors.New("Unable to connect to Haproxy socket: " + err.Error())
+	}
+	conn.SetDeadline(time.Now().Add(s.Timeout))
+	return conn, nil
+}
+
+// sends a command on a new connection and reads the reply until Haproxy closes it
+func (s *StatsSocket) singleCmd(cmd string) (string, error) {
+
+	conn, err := s.dial()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return "", err
+	}
+
+	reply, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+	return string(reply), nil
+}
+
+// sends a command on the persistent connection and reads the reply up to the prompt. A broken connection,
+// i.e. closed by Haproxy after the stats timeout, is dropped so the retry opens a new one.
+func (s *StatsSocket) interactiveCmd(cmd string) (string, error) {
+
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return "", err
+		}
+		s.conn = conn
+
+		if _, err := s.exchange("prompt\n"); err != nil {
+			s.closeConn()
+			return "", err
+		}
+	}
+
+	reply, err := s.exchange(cmd)
+	if err != nil {
+		s.closeConn()
+		return "", err
+	}
+	return reply, nil
+}
+
+func (s *StatsSocket) exchange(cmd string) (string, error) {
+
+	s.conn.SetDeadline(time.Now().Add(s.Timeout))
+
+	if _, err := s.conn.Write([]byte(cmd)); err != nil {
+		return "", err
+	}
+
+	var reply bytes.Buffer
+	buf := make([]byte, 4096)
+	for {
+		n, err := s.conn.Read(buf)
+		reply.Write(buf[:n])
+		if bytes.HasSuffix(reply.Bytes(), []byte(socketPrompt)) {
+			// without the prompt, the reply is the same as in non-interactive mode
+			return strings.TrimSuffix(reply.String(), "> "), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// maps the replies Haproxy uses to reject a command to errors
+func replyError(reply string) error {
+
+	switch reply = strings.TrimSpace(reply); {
+	case reply == ErrNoSuchServer.Error():
+		return ErrNoSuchServer
+	case reply == ErrNoSuchBackend.Error():
+		return ErrNoSuchBackend
+	case strings.HasPrefix(reply, "Permission denied"):
+		return ErrPermissionDenied
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package haproxy
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStatsSocket_Cmd(t *testing.T) {
+
+	conf := fakeRuntimeConfig(t)
+	runtime := newFakeRuntime(t, "/tmp/vamp_stats_socket_test.sock")
+	defer runtime.Close()
+	runtime.Reload(conf)
+
+	for _, persistent := range []bool{false, true} {
+
+		socket := NewStatsSocket("/tmp/vamp_stats_socket_test.sock")
+		socket.Persistent = persistent
+
+		if info, err := socket.Cmd("show info"); err != nil || len(info) == 0 {
+			t.Errorf("Failed to execute a command, persistent: %t", persistent)
+		}
+
+		if _, err := socket.Cmd("set weight " + BackendName("fake_route", "service_a") + "/no_such_server 10"); err != ErrNoSuchServer {
+			t.Errorf("Expected ErrNoSuchServer, got %v", err)
+		}
+
+		if _, err := socket.Cmd("set weight no_such_backend/server_a 10"); err != ErrNoSuchBackend {
+			t.Errorf("Expected ErrNoSuchBackend, got %v", err)
+		}
+
+		socket.Close()
+	}
+}
+
+func TestStatsSocket_Reconnect(t *testing.T) {
+
+	runtime := newFakeRuntime(t, "/tmp/vamp_stats_socket_test.sock")
+	defer runtime.Close()
+
+	socket := NewStatsSocket("/tmp/vamp_stats_socket_test.sock")
+	socket.Persistent = true
+	socket.RetryDelay = time.Millisecond
+	defer socket.Close()
+
+	if _, err := socket.Cmd("show info"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// a connection closed by Haproxy, i.e. after the stats timeout, is replaced on the next command
+	socket.conn.Close()
+
+	if _, err := socket.Cmd("show info"); err != nil {
+		t.Errorf("Failed to reconnect: %s", err.Error())
+	}
+}
+
+func TestStatsSocket_Errors(t *testing.T) {
+
+	socket := NewStatsSocket("/tmp/non_existent.sock")
+	socket.RetryDelay = time.Millisecond
+
+	if _, err := socket.Cmd("show info"); err == nil {
+		t.Errorf("Expected an error for a missing socket")
+	}
+
+	// a socket that never replies runs into the deadline
+	os.Remove("/tmp/vamp_stats_socket_silent.sock")
+	listener, err := net.Listen("unix", "/tmp/vamp_stats_socket_silent.sock")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer listener.Close()
+
+	socket = NewStatsSocket("/tmp/vamp_stats_socket_silent.sock")
+	socket.Timeout = 50 * time.Millisecond
+	socket.Retries = 0
+
+	start := time.Now()
+	if _, err := socket.Cmd("show info"); err == nil || time.Since(start) > time.Second {
+		t.Errorf("Expected a timeout on a silent socket")
+	}
+
+	if replyError("Permission denied\n\n") != ErrPermissionDenied {
+		t.Errorf("Failed to map a permission denied reply")
+	}
+}
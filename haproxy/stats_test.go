@@ -0,0 +1,72 @@
+package haproxy
+
+import (
+	"testing"
+	"time"
+)
+
+const statsOutput = `# pxname,svname,scur,status,weight,type,rtime,check_status,new_column,
+stats,FRONTEND,0,OPEN,,0,,,,
+test_fe_1,FRONTEND,3,OPEN,,0,,,12,
+test_be_1,server_1,2,UP 1/3,100,2,25,L4OK,7,
+test_be_1,server_2,0,MAINT(via),50,2,0,"no, check",,
+test_be_1,BACKEND,2,UP,150,1,25,,,
+
+`
+
+func TestStats_ParseStats(t *testing.T) {
+
+	stats, err := ParseStats(statsOutput)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(stats) != 4 {
+		t.Fatalf("Expected 4 proxies without the stats proxy, got %d", len(stats))
+	}
+
+	if fe := stats[0]; fe.Type != FRONTEND_TYPE || fe.Status != STATUS_OPEN || fe.Sessions.Current != 3 || fe.Counters["new_column"] != 12 {
+		t.Errorf("Failed to parse the frontend: %v", fe)
+	}
+
+	server := stats[1]
+	if server.Proxy != "test_be_1" || server.Server != "server_1" || server.Type != SERVER_TYPE {
+		t.Errorf("Failed to keep the identity of the server: %v", server)
+	}
+
+	if server.Status != STATUS_UP || server.Transition != "1/3" || server.Weight != 100 {
+		t.Errorf("Failed to parse the status and weight of the server: %v", server)
+	}
+
+	if time.Duration(server.Timings.Response) != 25*time.Millisecond || server.Fields["check_status"] != "L4OK" {
+		t.Errorf("Failed to parse the timings and fields of the server: %v", server)
+	}
+
+	if maint := stats[2]; maint.Status != STATUS_MAINT || maint.Transition != "via" || maint.Fields["check_status"] != "no, check" {
+		t.Errorf("Failed to parse the maintenance server: %v", maint)
+	}
+
+	if be := stats[3]; be.Type != BACKEND_TYPE || be.Weight != 150 {
+		t.Errorf("Failed to parse the backend: %v", be)
+	}
+}
+
+func TestStats_ParseInfo(t *testing.T) {
+
+	info, err := ParseInfo("Name: HAProxy\nVersion: 1.5.12\nRelease_date: 2015/05/02\nPid: 1234\nUptime: 0d 0h01m40s\nUptime_sec: 100\nNode: host:1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if info.Name != "HAProxy" || info.Version != "1.5.12" || info.Pid != 1234 || time.Duration(info.Uptime) != 100*time.Second {
+		t.Errorf("Failed to parse the info: %v", info)
+	}
+
+	if info.Fields["Node"] != "host:1" || info.Fields["Uptime"] != "0d 0h01m40s" {
+		t.Errorf("Failed to keep the untyped info: %v", info.Fields)
+	}
+
+	if _, err := ParseInfo("\n"); err == nil {
+		t.Errorf("Parsing empty info should fail")
+	}
+}
@@ -2,6 +2,7 @@ package haproxy
 
 import (
 	"sync"
+	"time"
 )
 
 /*
@@ -30,13 +31,17 @@ import (
   All items in a route map to actual Haproxy types from the vamp-loadbalancer/haproxy package.
 */
 type Route struct {
-	Name      string     `json:"name" binding:"required" valid:"routeName"`
-	Port      int        `json:"port" binding:"required"`
-	Protocol  string     `json:"protocol" binding:"required"`
-	HttpQuota Quota      `json:"httpQuota"`
-	TcpQuota  Quota      `json:"tcpQuota"`
-	Filters   []*Filter  `json:"filters"`
-	Services  []*Service `json:"services"`
+	Name       string     `json:"name" binding:"required" valid:"routeName"`
+	Port       int        `json:"port" binding:"required"`
+	Protocol   string     `json:"protocol" binding:"required"`
+	HttpQuota  Quota      `json:"httpQuota"`
+	TcpQuota   Quota      `json:"tcpQuota"`
+	Timeouts   Timeouts   `json:"timeouts"`
+	Retries    *int       `json:"retries,omitempty"`
+	Redispatch *bool      `json:"redispatch,omitempty"`
+	Mirror     *Mirror    `json:"mirror,omitempty"`
+	Filters    []*Filter  `json:"filters"`
+	Services   []*Service `json:"services"`
 }
 
 type Filter struct {
@@ -46,6 +51,21 @@ type Filter struct {
 	Negate      bool   `json:"negate,omitempty"`
 }
 
+/*
+  A Mirror sends a copy of a percentage of the requests of a route to one of its services. The responses to
+  these copies are discarded. Mirroring is done by a Lua action in the stable frontend of the route, which
+  sends the copies to a dedicated mirror frontend for the service. This means mirroring requires Haproxy 1.8
+  or higher, built with Lua support.
+
+  On a route, the service is the plain service name. On the stable frontend it is resolved to the full backend
+  name and the unix socket of the mirror frontend.
+*/
+type Mirror struct {
+	Service    string `json:"service" binding:"required"`
+	Percentage int    `json:"percentage" binding:"required"`
+	UnixSock   string `json:"unixSock,omitempty"`
+}
+
 type Quota struct {
 	SampleWindow string `json:"sampleWindow,omitempty" binding:"required"`
 	Rate         int    `json:"rate,omitempty" binding:"required"`
@@ -53,15 +73,40 @@ type Quota struct {
 }
 
 type Service struct {
-	Name    string    `json:"name" binding:"required"`
-	Weight  int       `json:"weight" binding:"required"`
-	Servers []*Server `json:"servers"`
+	Name       string    `json:"name" binding:"required"`
+	Weight     int       `json:"weight" binding:"required"`
+	Timeouts   Timeouts  `json:"timeouts"`
+	Retries    *int      `json:"retries,omitempty"`
+	Redispatch *bool     `json:"redispatch,omitempty"`
+	AllBackups bool      `json:"allBackups,omitempty"`
+	Failover   string    `json:"failover,omitempty"`
+	Slots      int       `json:"slots,omitempty"`
+	Servers    []*Server `json:"servers"`
+}
+
+/*
+  Timeouts map to the HAproxy "timeout" keywords. Values use the HAproxy time format, i.e. "500ms", "30s"
+  or "1h". Empty values are not rendered, so the value from the defaults section of the template applies.
+
+  Not every timeout is valid in every section: client timeouts are rendered in frontends, connect, server,
+  queue and tunnel timeouts in backends. The http-request and http-keep-alive timeouts are rendered in both.
+*/
+type Timeouts struct {
+	Connect       string `json:"connect,omitempty" valid:"duration"`
+	Client        string `json:"client,omitempty" valid:"duration"`
+	Server        string `json:"server,omitempty" valid:"duration"`
+	HttpRequest   string `json:"httpRequest,omitempty" valid:"duration"`
+	HttpKeepAlive string `json:"httpKeepAlive,omitempty" valid:"duration"`
+	Tunnel        string `json:"tunnel,omitempty" valid:"duration"`
+	Queue         string `json:"queue,omitempty" valid:"duration"`
 }
 
 type Server struct {
-	Name string `json:"name" binding:"required"`
-	Host string `json:"host" binding:"required"`
-	Port int    `json:"port" binding:"required"`
+	Name   string `json:"name" binding:"required"`
+	Host   string `json:"host" binding:"required"`
+	Port   int    `json:"port" binding:"required"`
+	Backup bool   `json:"backup,omitempty"`
+	State  string `json:"state,omitempty" valid:"serverState"`
 }
 
 type ServerDetail struct {
@@ -73,36 +118,79 @@ type ServerDetail struct {
 	MaxConn       int    `json:"maxconn"`
 	Check         bool   `json:"check"`
 	CheckInterval int    `json:"checkInterval"`
+	Backup        bool   `json:"backup,omitempty"`
+	State         string `json:"state,omitempty" valid:"serverState"`
+	Slot          bool   `json:"slot,omitempty"`
+	SlotServer    string `json:"slotServer,omitempty"`
+}
+
+/*
+  The state of a server. State is the admin state set through the API and persisted in the config,
+  Status is the operational status as reported by Haproxy, i.e. UP, DOWN, DRAIN or MAINT.
+*/
+type ServerState struct {
+	Name   string `json:"name"`
+	State  string `json:"state" valid:"serverState"`
+	Status string `json:"status"`
 }
 
 type Runtime struct {
-	Binary   string
-	SockFile string
+	Binary     string
+	SockFile   string
+	StateCheck *ServerStateCheck
+	LastReload *ReloadReport
+	Supervisor *Supervisor
+	Process    ProcessManager
+	Socket     *StatsSocket
+	reloads    int
+	reloadTime time.Duration
+}
+
+/*
+  Reports on the last reload of Haproxy: the pid of the old and new process and how long the handover took.
+  A seamless reload passes the listening sockets from the old to the new process.
+*/
+type ReloadReport struct {
+	Time     time.Time `json:"time"`
+	OldPid   string    `json:"oldPid"`
+	NewPid   string    `json:"newPid"`
+	Seamless bool      `json:"seamless"`
+	Duration string    `json:"duration"`
 }
 
 // Main configuration object for load balancers. This contains all variables and is passed to
 // the templating engine.
 type Config struct {
-	Frontends     []*Frontend   `json:"frontends" binding:"required"`
-	Backends      []*Backend    `json:"backends" binding:"required"`
-	Routes        []Route       `json:"routes" binding:"required"`
-	PidFile       string        `json:"-"`
-	SockFile      string        `json:"-"`
-	Mutex         *sync.RWMutex `json:"-"`
-	TemplateFile  string        `json:"-"`
-	ConfigFile    string        `json:"-"`
-	JsonFile      string        `json:"-"`
-	WorkingDir    string        `json:"-"`
-	ErrorPagesDir string        `json:"-"`
+	Frontends      []*Frontend   `json:"frontends" binding:"required"`
+	Backends       []*Backend    `json:"backends" binding:"required"`
+	Routes         []Route       `json:"routes" binding:"required"`
+	PidFile        string        `json:"-"`
+	SockFile       string        `json:"-"`
+	Mutex          *sync.RWMutex `json:"-"`
+	TemplateFile   string        `json:"-"`
+	ConfigFile     string        `json:"-"`
+	JsonFile       string        `json:"-"`
+	WorkingDir     string        `json:"-"`
+	ErrorPagesDir  string        `json:"-"`
+	LuaDir         string        `json:"-"`
+	StateFile      string        `json:"-"`
+	SeamlessReload bool          `json:"-"`
+	MasterWorker   bool          `json:"-"`
+	HardStopAfter  string        `json:"-" valid:"duration"`
+	Drains         []*Drain      `json:"-"`
+	Revision       int           `json:"revision"`
 }
 
 // Defines a single haproxy "backend".
 type Backend struct {
-	Name      string          `json:"name" binding:"required"`
-	Mode      string          `json:"mode" binding:"required"`
-	Servers   []*ServerDetail `json:"servers" binding:"required"`
-	Options   ProxyOptions    `json:"options"`
-	ProxyMode bool            `json:"proxyMode" binding:"required"`
+	Name       string          `json:"name" binding:"required"`
+	Mode       string          `json:"mode" binding:"required"`
+	Servers    []*ServerDetail `json:"servers" binding:"required"`
+	Options    ProxyOptions    `json:"options"`
+	ProxyMode  bool            `json:"proxyMode" binding:"required"`
+	Timeouts   Timeouts        `json:"timeouts"`
+	Retries    *int            `json:"retries,omitempty"`
+	Redispatch *bool           `json:"redispatch,omitempty"`
 }
 
 // Defines a single haproxy "frontend".
@@ -118,6 +206,21 @@ type Frontend struct {
 	Filters        []*Filter    `json:"filters,omitempty"`
 	HttpQuota      Quota        `json:"httpQuota,omitempty"`
 	TcpQuota       Quota        `json:"tcpQuota,omitempty"`
+	Timeouts       Timeouts     `json:"timeouts"`
+	Failovers      []*Failover  `json:"failovers,omitempty"`
+	Mirror         *Mirror      `json:"mirror,omitempty"`
+}
+
+/*
+  A Failover sends traffic meant for a backend to another backend as soon as the first one has no usable
+  servers left. When a filter is set, the failover only applies to traffic matching that filter.
+*/
+type Failover struct {
+	Name         string `json:"name" binding:"required"`
+	Backend      string `json:"backend" binding:"required"`
+	Destination  string `json:"destination" binding:"required"`
+	Filter       string `json:"filter,omitempty"`
+	NegateFilter bool   `json:"negateFilter,omitempty"`
 }
 
 type ProxyOptions struct {
@@ -0,0 +1,213 @@
+package haproxy
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	PROCESS_RUNNING = "running"
+	PROCESS_DOWN    = "down"
+)
+
+/*
+  The Supervisor watches the Haproxy processes in the pid file. Haproxy runs daemonized, so it is not a child
+  process we can wait on. Instead the pids are checked periodically and when none of them is alive anymore,
+  Haproxy is restarted from the last known-good config. Failing restarts are retried with an exponential
+  backoff.
+*/
+type Supervisor struct {
+	Runtime    *Runtime
+	Config     *Config
+	Interval   time.Duration
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	OnRestart  func(restarts int)
+	status     ProcessStatus
+	mutex      sync.RWMutex
+	stop       chan bool
+}
+
+// The status of the Haproxy processes as seen by the Supervisor
+type ProcessStatus struct {
+	Status       string    `json:"status"`
+	Pids         []int     `json:"pids"`
+	Restarts     int       `json:"restarts"`
+	LastExit     string    `json:"lastExit,omitempty"`
+	LastExitTime time.Time `json:"lastExitTime"`
+	LastError    string    `json:"lastError,omitempty"`
+}
+
+func NewSupervisor(r *Runtime, c *Config) *Supervisor {
+	return &Supervisor{
+		Runtime:    r,
+		Config:     c,
+		Interval:   2 * time.Second,
+		MinBackoff: 1 * time.Second,
+		MaxBackoff: 1 * time.Minute,
+		status:     ProcessStatus{Status: PROCESS_RUNNING, Pids: []int{}},
+		stop:       make(chan bool),
+	}
+}
+
+// starts supervising, blocks until stopped
+func (s *Supervisor) Start() {
+
+	wait := s.Interval
+	backoff := s.MinBackoff
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(wait):
+		}
+
+		if s.check() {
+			wait = s.Interval
+			backoff = s.MinBackoff
+		} else {
+			wait = backoff
+			if backoff *= 2; backoff > s.MaxBackoff {
+				backoff = s.MaxBackoff
+			}
+		}
+	}
+}
+
+func (s *Supervisor) Stop() {
+	s.stop <- true
+}
+
+func (s *Supervisor) Status() ProcessStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.status
+}
+
+// runs one supervision round and calls OnRestart after a restart, outside of any locks
+func (s *Supervisor) check() bool {
+
+	before := s.Status().Restarts
+	ok := s.supervise()
+
+	if restarts := s.Status().Restarts; restarts > before && s.OnRestart != nil {
+		s.OnRestart(restarts)
+	}
+	return ok
+}
+
+/*
+  Checks the Haproxy processes and restarts Haproxy when they are gone. Returns false when a restart failed.
+  Reloads write the pid file, so the config is locked for the whole check.
+*/
+func (s *Supervisor) supervise() bool {
+
+	s.Config.BeginWriteTrans()
+	defer s.Config.EndWriteTrans()
+
+	pids, _ := readPids(s.Config.PidFile)
+	alive := []int{}
+	for _, pid := range pids {
+		if pidAlive(pid) {
+			alive = append(alive, pid)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(alive) > 0 {
+		s.status.Status = PROCESS_RUNNING
+		s.status.Pids = alive
+		return true
+	}
+
+	// only record the exit once, not on every failed restart
+	if s.status.Status == PROCESS_RUNNING {
+		s.status.LastExit = "no Haproxy process running, last known pids: " + joinPids(pids)
+		if reason := s.Runtime.process().LastExit(); len(reason) > 0 {
+			s.status.LastExit += ", " + reason
+		}
+		s.status.LastExitTime = time.Now()
+	}
+	s.status.Status = PROCESS_DOWN
+	s.status.Pids = []int{}
+
+	if err := s.Runtime.Restart(s.Config); err != nil {
+		s.status.LastError = err.Error()
+		return false
+	}
+
+	s.status.Status = PROCESS_RUNNING
+	s.status.Restarts++
+	s.status.LastError = ""
+	if pids, err := readPids(s.Config.PidFile); err == nil {
+		s.status.Pids = pids
+	}
+	return true
+}
+
+// reads all pids from a pid file, one per line
+func readPids(pidfile string) ([]int, error) {
+
+	pids := []int{}
+
+	content, err := ioutil.ReadFile(pidfile)
+	if err != nil {
+		return pids, err
+	}
+
+	for _, field := range strings.Fields(string(content)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// checks if a process exists by sending it signal 0. A permission error still means it exists.
+// Signal 0 also succeeds on a zombie, which is what a crashed Haproxy becomes when the router is its parent, i.e.
+// when running as pid 1 in a container. Zombies are reaped and count as gone.
+func pidAlive(pid int) bool {
+
+	if pid <= 0 {
+		return false
+	}
+	if processState(pid) == "Z" {
+		syscall.Wait4(pid, nil, syscall.WNOHANG, nil)
+		return false
+	}
+	err := syscall.Kill(pid, syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// reads the state of a process from /proc/<pid>/stat, i.e. "S" or "Z" for a zombie. Returns an empty string
+// when it can not be read.
+func processState(pid int) string {
+
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return ""
+	}
+
+	// the command name between the parentheses can contain spaces, the state follows it
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+func joinPids(pids []int) string {
+
+	result := []string{}
+	for _, pid := range pids {
+		result = append(result, strconv.Itoa(pid))
+	}
+	return strings.Join(result, ",")
+}
@@ -0,0 +1,94 @@
+package haproxy
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSupervisor_RestartStoppedHaproxy(t *testing.T) {
+
+	// a pid of a process that is gone
+	cmd := exec.Command("true")
+	cmd.Run()
+	ioutil.WriteFile("/tmp/vamp_supervisor_test.pid", []byte(strconv.Itoa(cmd.ProcessState.Pid())), 0644)
+	defer os.Remove("/tmp/vamp_supervisor_test.pid")
+
+	// a fake Haproxy binary that "starts" by writing the pid of this test process
+	binary := "/tmp/vamp_fake_supervised_haproxy.sh"
+	script := "#!/bin/sh\necho " + strconv.Itoa(os.Getpid()) + " > /tmp/vamp_supervisor_test.pid\n"
+	if err := ioutil.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(binary)
+
+	conf := Config{ConfigFile: "/tmp/vamp_supervisor_test.cfg", PidFile: "/tmp/vamp_supervisor_test.pid"}
+	conf.InitializeConfig()
+	runtime := Runtime{Binary: binary}
+
+	restarted := 0
+	supervisor := NewSupervisor(&runtime, &conf)
+	supervisor.OnRestart = func(restarts int) { restarted = restarts }
+
+	if !supervisor.check() {
+		t.Fatalf("Failed to restart Haproxy: %v", supervisor.Status())
+	}
+
+	status := supervisor.Status()
+	if status.Status != PROCESS_RUNNING || status.Restarts != 1 || len(status.LastExit) == 0 || restarted != 1 {
+		t.Errorf("Failed to report the restart: %v", status)
+	}
+
+	if status.Pids[0] != os.Getpid() {
+		t.Errorf("Failed to report the new pid: %v", status.Pids)
+	}
+
+	// a running Haproxy is left alone
+	if !supervisor.check() || supervisor.Status().Restarts != 1 {
+		t.Errorf("Restarted a running Haproxy")
+	}
+
+	// a failing restart is reported
+	ioutil.WriteFile("/tmp/vamp_supervisor_test.pid", []byte(strconv.Itoa(cmd.ProcessState.Pid())), 0644)
+	runtime.Binary = "false"
+
+	if supervisor.check() {
+		t.Fatalf("Restart should fail")
+	}
+
+	if status := supervisor.Status(); status.Status != PROCESS_DOWN || len(status.LastError) == 0 {
+		t.Errorf("Failed to report the failing restart: %v", status)
+	}
+}
+
+func TestSupervisor_ZombieIsNotAlive(t *testing.T) {
+
+	// a child that exits without being waited for stays behind as a zombie
+	cmd := exec.Command("true")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	pid := cmd.Process.Pid
+
+	for i := 0; i < 100 && processState(pid) != "Z"; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if processState(pid) != "Z" {
+		t.Fatalf("Child did not become a zombie: %s", processState(pid))
+	}
+
+	if pidAlive(pid) {
+		t.Errorf("Zombie reported as alive")
+	}
+
+	if _, err := os.Stat("/proc/" + strconv.Itoa(pid)); !os.IsNotExist(err) {
+		t.Errorf("Failed to reap the zombie")
+	}
+
+	if !pidAlive(os.Getpid()) {
+		t.Errorf("Running process reported as gone")
+	}
+}
@@ -35,9 +35,31 @@ func init() {
 		socketPath := regexp.MustCompile(pattern)
 		return socketPath.MatchString(str)
 	})
+
+	// validation for durations in the Haproxy time format, i.e. 100ms, 30s, 5m. A value without a unit
+	// is taken as milliseconds by Haproxy. Empty values are allowed, they fall back to the defaults.
+	valid.TagMap["duration"] = valid.Validator(func(str string) bool {
+
+		pattern := "^([0-9]+(us|ms|s|m|h|d)?)?$"
+		duration := regexp.MustCompile(pattern)
+		return duration.MatchString(str)
+	})
+
+	// validation for the admin state of a server. Empty values are allowed and mean the server is ready.
+	valid.TagMap["serverState"] = valid.Validator(func(str string) bool {
+
+		pattern := "^(ready|drain|maint)?$"
+		state := regexp.MustCompile(pattern)
+		return state.MatchString(str)
+	})
 }
 
 // simple wrapper function to ease the validation
 func Validate(s interface{}) (bool, error) {
 	return valid.ValidateStruct(s)
 }
+
+// validates a single duration in the Haproxy time format, for settings that do not come in through the API
+func ValidDuration(str string) bool {
+	return valid.TagMap["duration"](str)
+}
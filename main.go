@@ -11,8 +11,12 @@ import (
 	"github.com/magneticio/vamp-router/zookeeper"
 	gologger "github.com/op/go-logging"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 const (
@@ -22,23 +26,55 @@ const (
 	pidFile        = "haproxy-private.pid"
 	sockFile       = "haproxy.stats.sock"
 	errorPagesDir  = "error_pages"
+	luaDir         = "lua"
+	stateFile      = "haproxy.state"
 	maxWorkDirSize = 50 // this value is based on (max socket path size - md5 hash length - pre and postfixes)
 )
 
 var (
 	// Set all commandline arguments
-	port          int
-	logPath       string
-	configPath    string
-	binaryPath    string
-	kafkaHost     string
-	kafkaPort     int
-	zooConString  string
-	zooConKey     string
-	headless      bool
-	log           *gologger.Logger
-	workDir       helpers.WorkDir
-	customWorkDir string
+	port               int
+	logPath            string
+	configPath         string
+	binaryPath         string
+	kafkaHost          string
+	kafkaPort          int
+	kafkaBrokers       string
+	kafkaTopic         string
+	kafkaTopicTemplate string
+	kafkaCompression   string
+	kafkaVersion       string
+	kafkaTLS           bool
+	kafkaTLSCA         string
+	kafkaTLSCert       string
+	kafkaTLSKey        string
+	kafkaSASLUser      string
+	kafkaSASLPassword  string
+	statsdHost         string
+	statsdPort         int
+	statsdPrefix       string
+	dogStatsd          bool
+	graphiteHost       string
+	graphitePort       int
+	graphitePrefix     string
+	influxdbUrl        string
+	streamMetrics      string
+	frontendMetrics    string
+	pollInterval       int
+	socketServers      bool
+	zooConString       string
+	zooConKey          string
+	headless           bool
+	serverState        bool
+	log                *gologger.Logger
+	workDir            helpers.WorkDir
+	customWorkDir      string
+	seamless           bool
+	hardStopAfter      string
+	supervise          bool
+	masterWorker       bool
+	masterSock         string
+	fakeRuntime        bool
 )
 
 func init() {
@@ -48,10 +84,40 @@ func init() {
 	flag.StringVar(&binaryPath, "binary", helpers.HaproxyLocation(), "Path to the HAproxy binary")
 	flag.StringVar(&kafkaHost, "kafkaHost", "", "The hostname or ip address of the Kafka host")
 	flag.IntVar(&kafkaPort, "kafkaPort", 9092, "The port of the Kafka host")
+	flag.StringVar(&kafkaBrokers, "kafkaBrokers", "", "Comma separated list of Kafka brokers, i.e. kafka1:9092,kafka2:9092")
+	flag.StringVar(&kafkaTopic, "kafkaTopic", "loadbalancer.all", "The Kafka topic to publish metrics to")
+	flag.StringVar(&kafkaTopicTemplate, "kafkaTopicTemplate", "", "Publish the metrics of a route to their own Kafka topic, i.e. loadbalancer.{route}")
+	flag.StringVar(&kafkaCompression, "kafkaCompression", "snappy", "Compression of Kafka messages: none, gzip, snappy or lz4")
+	flag.StringVar(&kafkaVersion, "kafkaVersion", "1.0.0", "Version of the Kafka brokers, i.e. 0.10.2.0 or 2.1.0")
+	flag.BoolVar(&kafkaTLS, "kafkaTLS", false, "Connect to Kafka over TLS")
+	flag.StringVar(&kafkaTLSCA, "kafkaTLSCA", "", "CA certificate file to verify the Kafka brokers, defaults to the system roots")
+	flag.StringVar(&kafkaTLSCert, "kafkaTLSCert", "", "Client certificate file for Kafka")
+	flag.StringVar(&kafkaTLSKey, "kafkaTLSKey", "", "Client key file for Kafka")
+	flag.StringVar(&kafkaSASLUser, "kafkaSASLUser", "", "SASL/PLAIN user for Kafka")
+	flag.StringVar(&kafkaSASLPassword, "kafkaSASLPassword", "", "SASL/PLAIN password for Kafka")
+	flag.StringVar(&statsdHost, "statsdHost", "", "The hostname or ip address of the StatsD host")
+	flag.IntVar(&statsdPort, "statsdPort", 8125, "The port of the StatsD host")
+	flag.StringVar(&statsdPrefix, "statsdPrefix", "vamp", "Prefix of the metric names sent to StatsD")
+	flag.BoolVar(&dogStatsd, "dogStatsd", false, "Send the route, service and server as DogStatsD tags instead of in the metric name")
+	flag.StringVar(&graphiteHost, "graphiteHost", "", "The hostname or ip address of the Graphite host")
+	flag.IntVar(&graphitePort, "graphitePort", 2003, "The plaintext port of the Graphite host")
+	flag.StringVar(&graphitePrefix, "graphitePrefix", "vamp", "Prefix of the metric names sent to Graphite")
+	flag.StringVar(&influxdbUrl, "influxdbUrl", "", "The InfluxDB write endpoint, i.e. http://localhost:8086/write?db=vamp&precision=s")
+	flag.StringVar(&streamMetrics, "metrics", "", "Comma separated list of the stats emitted for backends and servers, defaults to a common set")
+	flag.StringVar(&frontendMetrics, "frontendMetrics", "", "Comma separated list of the stats emitted for frontends, defaults to ereq,rate_lim,req_rate_max,req_rate")
+	flag.IntVar(&pollInterval, "pollInterval", 3000, "Milliseconds between two polls of the HAproxy stats")
+	flag.BoolVar(&socketServers, "socketServerMetrics", false, "Also emit the metrics of the servers routing a route to its services over their sockets")
 	flag.StringVar(&zooConString, "zooConString", "", "A zookeeper ensemble connection string")
 	flag.StringVar(&zooConKey, "zooConKey", "magneticio/vamplb", "Zookeeper root key")
 	flag.StringVar(&customWorkDir, "customWorkDir", "", "Custom working directory for sockets and pid files, default to data/")
 	flag.BoolVar(&headless, "headless", false, "Run without any logging output to the console")
+	flag.BoolVar(&serverState, "serverState", false, "Keep the server state across reloads in a state file, needs HAproxy 1.6+")
+	flag.BoolVar(&seamless, "seamlessReload", false, "Pass the listening sockets to the new HAproxy process on reloads, needs HAproxy 1.8+")
+	flag.BoolVar(&masterWorker, "masterWorker", false, "Run HAproxy in master-worker mode as a child process, needs HAproxy 1.8+")
+	flag.StringVar(&masterSock, "masterSock", "", "Path to the master CLI socket in master-worker mode, needs HAproxy 1.9+")
+	flag.BoolVar(&supervise, "supervise", true, "Restart HAproxy from the last known-good config when it stops")
+	flag.StringVar(&hardStopAfter, "hardStopAfter", "", "Maximum time an old HAproxy process may take to finish after a reload, i.e. 30s")
+	flag.BoolVar(&fakeRuntime, "fakeRuntime", false, "Test only: run against an in-process fake of the HAproxy stats socket, no traffic is routed")
 }
 
 func main() {
@@ -65,10 +131,40 @@ func main() {
 	tools.SetValueFromEnv(&binaryPath, "VAMP_RT_BINARY_PATH")
 	tools.SetValueFromEnv(&kafkaHost, "VAMP_RT_KAFKA_HOST")
 	tools.SetValueFromEnv(&kafkaPort, "VAMP_RT_KAFKA_PORT")
+	tools.SetValueFromEnv(&kafkaBrokers, "VAMP_RT_KAFKA_BROKERS")
+	tools.SetValueFromEnv(&kafkaTopic, "VAMP_RT_KAFKA_TOPIC")
+	tools.SetValueFromEnv(&kafkaTopicTemplate, "VAMP_RT_KAFKA_TOPIC_TEMPLATE")
+	tools.SetValueFromEnv(&kafkaCompression, "VAMP_RT_KAFKA_COMPRESSION")
+	tools.SetValueFromEnv(&kafkaVersion, "VAMP_RT_KAFKA_VERSION")
+	tools.SetValueFromEnv(&kafkaTLS, "VAMP_RT_KAFKA_TLS")
+	tools.SetValueFromEnv(&kafkaTLSCA, "VAMP_RT_KAFKA_TLS_CA")
+	tools.SetValueFromEnv(&kafkaTLSCert, "VAMP_RT_KAFKA_TLS_CERT")
+	tools.SetValueFromEnv(&kafkaTLSKey, "VAMP_RT_KAFKA_TLS_KEY")
+	tools.SetValueFromEnv(&kafkaSASLUser, "VAMP_RT_KAFKA_SASL_USER")
+	tools.SetValueFromEnv(&kafkaSASLPassword, "VAMP_RT_KAFKA_SASL_PASSWORD")
+	tools.SetValueFromEnv(&statsdHost, "VAMP_RT_STATSD_HOST")
+	tools.SetValueFromEnv(&statsdPort, "VAMP_RT_STATSD_PORT")
+	tools.SetValueFromEnv(&statsdPrefix, "VAMP_RT_STATSD_PREFIX")
+	tools.SetValueFromEnv(&dogStatsd, "VAMP_RT_DOGSTATSD")
+	tools.SetValueFromEnv(&graphiteHost, "VAMP_RT_GRAPHITE_HOST")
+	tools.SetValueFromEnv(&graphitePort, "VAMP_RT_GRAPHITE_PORT")
+	tools.SetValueFromEnv(&graphitePrefix, "VAMP_RT_GRAPHITE_PREFIX")
+	tools.SetValueFromEnv(&influxdbUrl, "VAMP_RT_INFLUXDB_URL")
+	tools.SetValueFromEnv(&streamMetrics, "VAMP_RT_METRICS")
+	tools.SetValueFromEnv(&frontendMetrics, "VAMP_RT_FRONTEND_METRICS")
+	tools.SetValueFromEnv(&pollInterval, "VAMP_RT_POLL_INTERVAL")
+	tools.SetValueFromEnv(&socketServers, "VAMP_RT_SOCKET_SERVER_METRICS")
 	tools.SetValueFromEnv(&zooConString, "VAMP_RT_ZOO_STRING")
 	tools.SetValueFromEnv(&zooConKey, "VAMP_RT_ZOO_KEY")
 	tools.SetValueFromEnv(&customWorkDir, "VAMP_RT_CUSTOM_WORKDIR")
 	tools.SetValueFromEnv(&headless, "VAMP_RT_HEADLESS")
+	tools.SetValueFromEnv(&serverState, "VAMP_RT_SERVER_STATE")
+	tools.SetValueFromEnv(&seamless, "VAMP_RT_SEAMLESS_RELOAD")
+	tools.SetValueFromEnv(&hardStopAfter, "VAMP_RT_HARD_STOP_AFTER")
+	tools.SetValueFromEnv(&supervise, "VAMP_RT_SUPERVISE")
+	tools.SetValueFromEnv(&masterWorker, "VAMP_RT_MASTER_WORKER")
+	tools.SetValueFromEnv(&masterSock, "VAMP_RT_MASTER_SOCK")
+	tools.SetValueFromEnv(&fakeRuntime, "VAMP_RT_FAKE_RUNTIME")
 
 	// setup logging
 	log = logging.ConfigureLog(logPath, headless)
@@ -99,6 +195,10 @@ func main() {
 		HAproxy runtime and configuration setup
 	*/
 
+	if !haproxy.ValidDuration(hardStopAfter) {
+		log.Fatal("Invalid hardStopAfter duration: " + hardStopAfter)
+	}
+
 	// TODO: refactor haRuntime struct to just include haConfig
 	// setup Haproxy runtime
 	haRuntime := haproxy.Runtime{
@@ -106,6 +206,39 @@ func main() {
 		SockFile: filepath.Join(workDir.Dir(), "/", sockFile),
 	}
 
+	// the metrics are polled over one persistent connection instead of a new connection per poll
+	haRuntime.Socket = haproxy.NewStatsSocket(haRuntime.SockFile)
+	haRuntime.Socket.Persistent = true
+
+	// the API and metrics use the runtime through its interface, so a fake can stand in for Haproxy. The fake is
+	// only meant for testing clients of the router, it routes no traffic.
+	var runtimeProvider haproxy.RuntimeProvider = &haRuntime
+	if fakeRuntime {
+		log.Warning("Running against a fake HAproxy runtime, no traffic will be routed")
+		fake, err := haproxy.NewFakeRuntime(haRuntime.SockFile)
+		if err != nil {
+			log.Fatal("Could not start the fake HAproxy runtime: " + err.Error())
+		}
+		runtimeProvider = fake
+	}
+
+	// in master-worker mode Haproxy runs as a child process and stops together with the router
+	if masterWorker && !fakeRuntime {
+		log.Notice("Running HAproxy in master-worker mode")
+		haRuntime.Process = haproxy.NewMasterWorkerProcess(masterSock)
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			<-signals
+			log.Notice("Stopping HAproxy...")
+			if err := haRuntime.Stop(); err != nil {
+				log.Error(err.Error())
+			}
+			os.Exit(0)
+		}()
+	}
+
 	// setup configuration. Use custom path if provided, otherwise use install dir
 	if len(configPath) == 0 {
 		installDir, err := filepath.Abs(filepath.Dir(os.Args[0]))
@@ -117,13 +250,22 @@ func main() {
 	}
 
 	haConfig := haproxy.Config{
-		TemplateFile:  filepath.Join(configPath, templateFile),
-		ConfigFile:    filepath.Join(configPath, configFile),
-		JsonFile:      filepath.Join(configPath, jsonFile),
-		ErrorPagesDir: filepath.Join(configPath, errorPagesDir, "/"),
-		PidFile:       filepath.Join(workDir.Dir(), "/", pidFile),
-		SockFile:      filepath.Join(workDir.Dir(), "/", sockFile),
-		WorkingDir:    filepath.Join(workDir.Dir() + "/"),
+		TemplateFile:   filepath.Join(configPath, templateFile),
+		ConfigFile:     filepath.Join(configPath, configFile),
+		JsonFile:       filepath.Join(configPath, jsonFile),
+		ErrorPagesDir:  filepath.Join(configPath, errorPagesDir, "/"),
+		LuaDir:         filepath.Join(configPath, luaDir, "/"),
+		PidFile:        filepath.Join(workDir.Dir(), "/", pidFile),
+		SockFile:       filepath.Join(workDir.Dir(), "/", sockFile),
+		SeamlessReload: seamless,
+		MasterWorker:   masterWorker,
+		HardStopAfter:  hardStopAfter,
+		WorkingDir:     filepath.Join(workDir.Dir() + "/"),
+	}
+
+	// the server-state-file directives are only understood by HAproxy 1.6+
+	if serverState {
+		haConfig.StateFile = filepath.Join(workDir.Dir(), "/", stateFile)
 	}
 
 	log.Notice("Attempting to load config at %s", configPath)
@@ -146,7 +288,7 @@ func main() {
 		log.Notice("Created new pidfile...")
 	}
 
-	err = haRuntime.Reload(&haConfig)
+	err = runtimeProvider.Reload(&haConfig)
 	if err != nil {
 		log.Fatal("Error while reloading haproxy: " + err.Error())
 		os.Exit(1)
@@ -158,37 +300,68 @@ func main() {
 
 	log.Notice("Initializing metric streams...")
 
-	Stream := metrics.NewStreamer(&haRuntime, 3000, log)
+	Stream := metrics.NewStreamer(runtimeProvider, pollInterval, log)
+	Stream.HaConfig = &haConfig
+	if err := Stream.SetConfig(streamerConfig()); err != nil {
+		log.Fatal("Invalid metrics configuration: " + err.Error())
+	}
 	// Initialize the stream from a runtime
 	// stream.Init(&haRuntime, 3000, log)
 
-	// Setup Kafka if required
-	if len(kafkaHost) > 0 {
-
-		kafkaChannel := make(chan metrics.Metric)
-		Stream.AddClient(kafkaChannel)
-
-		kafka := metrics.KafkaProducer{Log: log}
-		kafka.In(kafkaChannel)
-		kafka.Start(kafkaHost, kafkaPort)
-
-	}
+	// Setup the producers, the ones configured by flags or environment variables are enabled
+	producerChannel := make(chan metrics.Metric, 10000)
+	Stream.AddClient(producerChannel)
+
+	producers := metrics.NewProducerRegistry(producerChannel, log)
+	producers.Configure(metrics.ProducerSettings{
+		KafkaBrokers:       kafkaBrokerList(),
+		KafkaTopic:         kafkaTopic,
+		KafkaTopicTemplate: kafkaTopicTemplate,
+		KafkaCompression:   kafkaCompression,
+		KafkaVersion:       kafkaVersion,
+		KafkaTLS:           kafkaTLS,
+		KafkaTLSCAFile:     kafkaTLSCA,
+		KafkaTLSCertFile:   kafkaTLSCert,
+		KafkaTLSKeyFile:    kafkaTLSKey,
+		KafkaSASLUser:      kafkaSASLUser,
+		KafkaSASLPassword:  kafkaSASLPassword,
+		StatsdHost:         statsdHost,
+		StatsdPort:         statsdPort,
+		StatsdPrefix:       statsdPrefix,
+		DogStatsd:          dogStatsd,
+		GraphiteHost:       graphiteHost,
+		GraphitePort:       graphitePort,
+		GraphitePrefix:     graphitePrefix,
+		InfluxdbUrl:        influxdbUrl,
+	})
+	go producers.Start()
 
 	sseChannel := make(chan metrics.Metric, 10000)
 	Stream.AddClient(sseChannel)
 
 	// Always setup SSE Stream
-	sseBroker := &metrics.SSEBroker{
-		make(map[chan metrics.Metric]bool),
-		make(chan (chan metrics.Metric)),
-		make(chan (chan metrics.Metric)),
-		sseChannel,
-		log,
-	}
+	sseBroker := metrics.NewSSEBroker(sseChannel, log)
 
 	go sseBroker.Start()
 	go Stream.Start()
 
+	/*
+		HAproxy supervision setup
+	*/
+
+	if supervise && !fakeRuntime {
+
+		log.Notice("Supervising HAproxy...")
+		haRuntime.Supervisor = haproxy.NewSupervisor(&haRuntime, &haConfig)
+
+		// every restart is emitted as a metric on all streams
+		haRuntime.Supervisor.OnRestart = func(restarts int) {
+			log.Warning("HAproxy stopped, restarted it from the last known-good config")
+			metrics.EmitMetric(time.Now().Format(time.RFC3339), []string{"router", "haproxy"}, "restarts", strconv.Itoa(restarts), Stream.Clients)
+		}
+		go haRuntime.Supervisor.Start()
+	}
+
 	/*
 		Zookeeper setup
 	*/
@@ -209,10 +382,47 @@ func main() {
 		Rest API setup
 	*/
 	log.Notice("Initializing REST API...")
-	if restApi, err := api.CreateApi(log, &haConfig, &haRuntime, sseBroker, Version); err != nil {
+	if restApi, err := api.CreateApi(log, &haConfig, runtimeProvider, sseBroker, producers, Stream, Version); err != nil {
 		panic("failed to create REST Api")
 	} else {
 		restApi.Run("0.0.0.0:" + strconv.Itoa(port))
 	}
 
 }
+
+// the Kafka brokers from -kafkaBrokers, with the broker from -kafkaHost and -kafkaPort
+func kafkaBrokerList() []string {
+
+	brokers := commaList(kafkaBrokers)
+	if len(kafkaHost) > 0 {
+		brokers = append(brokers, kafkaHost+":"+strconv.Itoa(kafkaPort))
+	}
+	return brokers
+}
+
+// the streamer config from -metrics, -frontendMetrics, -pollInterval and -socketServerMetrics
+func streamerConfig() metrics.StreamerConfig {
+
+	config := metrics.DefaultStreamerConfig()
+	if list := commaList(streamMetrics); len(list) > 0 {
+		config.Metrics = list
+	}
+	if list := commaList(frontendMetrics); len(list) > 0 {
+		config.FrontendMetrics = list
+	}
+	config.PollInterval = pollInterval
+	config.SocketServers = socketServers
+	return config
+}
+
+// splits a comma separated flag, leaving out empty values
+func commaList(value string) []string {
+
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+/*
+  The Deriver keeps the previous sample of the counters of every proxy, so the streamer can emit what
+  consumers would otherwise compute themselves:
+
+      <counter>_rate     the per second rate of a counter since the previous sample, i.e. req_tot_rate
+      hrsp_4xx_ratio     the fraction of the responses since the previous sample that were a 4xx
+      hrsp_5xx_ratio     the fraction of the responses since the previous sample that were a 5xx
+
+  Counters start at zero again when Haproxy reloads without keeping its stats, or when the runtime is reset.
+  When any counter of a proxy went down, the sample only becomes the new baseline and nothing is derived, so
+  a reset never shows up as a negative rate. A Deriver is only used by the routine parsing the stats.
+*/
+type Deriver struct {
+	previous map[string]counterSample
+}
+
+// the counters of a proxy at the time they were read
+type counterSample struct {
+	time   time.Time
+	values map[string]float64
+}
+
+// a value derived from the counters of a proxy
+type DerivedMetric struct {
+	Name  string
+	Value float64
+}
+
+// the counters of which a per second rate is derived
+var rateCounters = []string{"stot", "req_tot", "bin", "bout", "ereq", "econ", "eresp", "hrsp_1xx", "hrsp_2xx", "hrsp_3xx", "hrsp_4xx", "hrsp_5xx"}
+
+// the response counters that together count all responses
+var responseCounters = []string{"hrsp_1xx", "hrsp_2xx", "hrsp_3xx", "hrsp_4xx", "hrsp_5xx", "hrsp_other"}
+
+// the response counters of which the fraction of all responses is derived
+var ratioCounters = []string{"hrsp_4xx", "hrsp_5xx"}
+
+func NewDeriver() *Deriver {
+	return &Deriver{previous: make(map[string]counterSample)}
+}
+
+/*
+  Derives the metrics of a proxy from its counters read at the given time, and keeps them for the next
+  sample. Returns nil for the first sample of a proxy and after a reset of its counters.
+*/
+func (d *Deriver) Derive(key string, proxy map[string]string, now time.Time) []DerivedMetric {
+
+	sample := counterSample{now, make(map[string]float64)}
+	for _, counters := range [][]string{rateCounters, responseCounters} {
+		for _, counter := range counters {
+			if value, err := strconv.ParseFloat(proxy[counter], 64); err == nil {
+				sample.values[counter] = value
+			}
+		}
+	}
+
+	previous, ok := d.previous[key]
+	d.previous[key] = sample
+
+	elapsed := now.Sub(previous.time).Seconds()
+	if !ok || elapsed <= 0 || isReset(previous, sample) {
+		return nil
+	}
+
+	derived := []DerivedMetric{}
+
+	for _, counter := range rateCounters {
+		if delta, ok := counterDelta(previous, sample, counter); ok {
+			derived = append(derived, DerivedMetric{counter + "_rate", roundDerived(delta / elapsed)})
+		}
+	}
+
+	responses := 0.0
+	for _, counter := range responseCounters {
+		delta, ok := counterDelta(previous, sample, counter)
+		if !ok {
+			return derived
+		}
+		responses += delta
+	}
+
+	for _, counter := range ratioCounters {
+		ratio := 0.0
+		if responses > 0 {
+			delta, _ := counterDelta(previous, sample, counter)
+			ratio = delta / responses
+		}
+		derived = append(derived, DerivedMetric{counter + "_ratio", roundDerived(ratio)})
+	}
+
+	return derived
+}
+
+// forgets the proxies that are not in the stats anymore, so removed routes do not pile up
+func (d *Deriver) Forget(stats map[string]map[string]string) {
+	for key := range d.previous {
+		if _, ok := stats[key]; !ok {
+			delete(d.previous, key)
+		}
+	}
+}
+
+// checks if any counter went down since the previous sample
+func isReset(previous counterSample, sample counterSample) bool {
+	for counter, value := range sample.values {
+		if old, ok := previous.values[counter]; ok && value < old {
+			return true
+		}
+	}
+	return false
+}
+
+func counterDelta(previous counterSample, sample counterSample, counter string) (float64, bool) {
+
+	old, ok := previous.values[counter]
+	if !ok {
+		return 0, false
+	}
+	value, ok := sample.values[counter]
+	if !ok {
+		return 0, false
+	}
+	return value - old, true
+}
+
+// rounds to three decimals, more precision than that is just noise of the polling
+func roundDerived(value float64) float64 {
+	return math.Floor(value*1000+0.5) / 1000
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func derivedValues(derived []DerivedMetric) map[string]float64 {
+	values := make(map[string]float64)
+	for _, d := range derived {
+		values[d.Name] = d.Value
+	}
+	return values
+}
+
+func TestDeriver_RatesAndRatios(t *testing.T) {
+
+	deriver := NewDeriver()
+	start := time.Now()
+
+	first := map[string]string{"req_tot": "100", "stot": "10", "hrsp_1xx": "0", "hrsp_2xx": "80", "hrsp_3xx": "0", "hrsp_4xx": "10", "hrsp_5xx": "10", "hrsp_other": "0"}
+	if derived := deriver.Derive("r:FRONTEND", first, start); derived != nil {
+		t.Errorf("Expected nothing derived from the first sample, got %v", derived)
+	}
+
+	second := map[string]string{"req_tot": "300", "stot": "15", "hrsp_1xx": "0", "hrsp_2xx": "230", "hrsp_3xx": "0", "hrsp_4xx": "20", "hrsp_5xx": "50", "hrsp_other": "0"}
+	values := derivedValues(deriver.Derive("r:FRONTEND", second, start.Add(2*time.Second)))
+
+	expected := map[string]float64{"req_tot_rate": 100, "stot_rate": 2.5, "hrsp_5xx_rate": 20, "hrsp_4xx_ratio": 0.05, "hrsp_5xx_ratio": 0.2}
+	for name, value := range expected {
+		if values[name] != value {
+			t.Errorf("Expected %s to be %v, got %v", name, value, values[name])
+		}
+	}
+	if _, ok := values["bin_rate"]; ok {
+		t.Errorf("Expected no rate for a counter missing from the stats")
+	}
+}
+
+func TestDeriver_Reset(t *testing.T) {
+
+	deriver := NewDeriver()
+	start := time.Now()
+
+	deriver.Derive("r:BACKEND", map[string]string{"stot": "50", "econ": "5"}, start)
+
+	// after a reload the counters start at zero again
+	if derived := deriver.Derive("r:BACKEND", map[string]string{"stot": "3", "econ": "5"}, start.Add(time.Second)); derived != nil {
+		t.Errorf("Expected nothing derived after a reset, got %v", derived)
+	}
+
+	values := derivedValues(deriver.Derive("r:BACKEND", map[string]string{"stot": "7", "econ": "5"}, start.Add(2*time.Second)))
+	if values["stot_rate"] != 4 || values["econ_rate"] != 0 {
+		t.Errorf("Expected rates from the sample after the reset, got %v", values)
+	}
+
+	// a proxy that is gone is forgotten, so it starts over when it comes back
+	deriver.Forget(map[string]map[string]string{})
+	if derived := deriver.Derive("r:BACKEND", map[string]string{"stot": "9"}, start.Add(3*time.Second)); derived != nil {
+		t.Errorf("Expected a forgotten proxy to start over, got %v", derived)
+	}
+}
@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"errors"
+	gologger "github.com/op/go-logging"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+  The GraphiteProducer sends the metrics stream to Graphite using the plaintext protocol over TCP, one line
+  per metric with the dotted path of its tags:
+
+      vamp.routes.r.services.s.scur 3 1424803507
+
+  Lines are sent in batches of BatchSize, or after the FlushInterval. When Graphite cannot be reached, the
+  lines are kept and sent after reconnecting, up to MaxBuffered lines. Older lines are dropped beyond that.
+*/
+type GraphiteProducer struct {
+	Host          string
+	Port          int
+	Prefix        string
+	BatchSize     int
+	FlushInterval time.Duration
+	MaxBuffered   int
+	Log           *gologger.Logger
+
+	metricsChannel chan Metric
+	conn           *reconnectingConn
+	buffered       []string
+	producerState
+}
+
+func NewGraphiteProducer(host string, port int, prefix string, log *gologger.Logger) *GraphiteProducer {
+	return &GraphiteProducer{
+		Host:          host,
+		Port:          port,
+		Prefix:        prefix,
+		BatchSize:     500,
+		FlushInterval: time.Second,
+		MaxBuffered:   50000,
+		Log:           log,
+	}
+}
+
+func (g *GraphiteProducer) Name() string {
+	return "graphite"
+}
+
+func (g *GraphiteProducer) Start(c chan Metric) error {
+
+	if len(g.Host) == 0 {
+		return errors.New("no Graphite host configured")
+	}
+
+	connection := g.Host + ":" + strconv.Itoa(g.Port)
+	g.Log.Notice("Sending metrics to Graphite on " + connection)
+
+	g.metricsChannel = c
+	g.conn = newReconnectingConn("tcp", connection)
+	g.started()
+
+	go func() {
+		defer g.stopped()
+		batchLines(g.metricsChannel, g.format, g.BatchSize, g.FlushInterval, g.flush)
+	}()
+	return nil
+}
+
+// the lines still buffered when stopping are lost
+func (g *GraphiteProducer) Stop() error {
+	g.wait()
+	g.buffered = nil
+	return g.conn.Close()
+}
+
+func (g *GraphiteProducer) format(metric Metric) string {
+
+	timestamp := time.Now()
+	if t, err := time.Parse(time.RFC3339, metric.Timestamp); err == nil {
+		timestamp = t
+	}
+	return MetricPath(g.Prefix, metric.Tags) + " " + formatValue(metric.Value) + " " + strconv.FormatInt(timestamp.Unix(), 10)
+}
+
+func (g *GraphiteProducer) flush(lines []string) {
+
+	g.buffered = append(g.buffered, lines...)
+	if dropped := len(g.buffered) - g.MaxBuffered; dropped > 0 {
+		g.Log.Warning("Dropping %d metrics that could not be sent to Graphite", dropped)
+		g.buffered = g.buffered[dropped:]
+		g.dropped(dropped)
+	}
+	if len(g.buffered) == 0 {
+		return
+	}
+
+	if n, err := g.conn.Write([]byte(strings.Join(g.buffered, "\n") + "\n")); err != nil {
+
+		// the connection is closed after a failed write, so Graphite discards a partly written line. Only
+		// the lines that were written completely are not sent again.
+		if sent := writtenLines(g.buffered, n); sent > 0 {
+			g.produced(sent)
+			g.buffered = g.buffered[sent:]
+		}
+		if err != errBackoff {
+			g.Log.Error("Error sending metrics to Graphite: " + err.Error())
+			g.failed(err, false)
+		}
+		return
+	}
+	g.produced(len(g.buffered))
+	g.buffered = nil
+}
+
+// counts the lines written completely by a write of n bytes, each line followed by a newline
+func writtenLines(lines []string, n int) int {
+
+	for i, line := range lines {
+		if n -= len(line) + 1; n < 0 {
+			return i
+		}
+	}
+	return len(lines)
+}
@@ -0,0 +1,227 @@
+package metrics
+
+import (
+	"errors"
+	gologger "github.com/op/go-logging"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+  The InfluxdbProducer writes the metrics stream to the HTTP write endpoint of InfluxDB using the line
+  protocol, with the route, service, server and metric as tags and the timestamp in seconds:
+
+      vamp_router,route=r,service=s,server=x,kind=server,metric=scur value=3i 1424803507
+
+  The URL is the full write endpoint, including the database and precision, i.e.
+  "http://localhost:8086/write?db=vamp&precision=s".
+
+  Derived rates and ratios are fractions, so they are written as floats to a measurement of their own, i.e.
+  "vamp_router_derived", as InfluxDB does not allow mixing integers and floats in one field.
+
+  Lines are written in batches of BatchSize, or after the FlushInterval. While InfluxDB is unavailable, the
+  lines are buffered in memory, up to MaxBuffered lines, and the write is retried with a backoff that doubles
+  up to MaxBackoff. Batches that InfluxDB rejects as invalid are dropped.
+*/
+type InfluxdbProducer struct {
+	URL           string
+	Measurement   string
+	BatchSize     int
+	FlushInterval time.Duration
+	MaxBuffered   int
+	Backoff       time.Duration
+	MaxBackoff    time.Duration
+	Client        *http.Client
+	Log           *gologger.Logger
+
+	metricsChannel chan Metric
+	buffered       []string
+	backoff        time.Duration
+	nextWrite      time.Time
+	producerState
+}
+
+func NewInfluxdbProducer(url string, log *gologger.Logger) *InfluxdbProducer {
+	return &InfluxdbProducer{
+		URL:           url,
+		Measurement:   "vamp_router",
+		BatchSize:     500,
+		FlushInterval: time.Second,
+		MaxBuffered:   50000,
+		Backoff:       time.Second,
+		MaxBackoff:    time.Minute,
+		Client:        &http.Client{Timeout: 10 * time.Second},
+		Log:           log,
+	}
+}
+
+func (i *InfluxdbProducer) Name() string {
+	return "influxdb"
+}
+
+func (i *InfluxdbProducer) Start(c chan Metric) error {
+
+	if len(i.URL) == 0 {
+		return errors.New("no InfluxDB URL configured")
+	}
+
+	i.Log.Notice("Writing metrics to InfluxDB on " + i.URL)
+
+	i.metricsChannel = c
+	i.started()
+
+	go func() {
+		defer i.stopped()
+		batchLines(i.metricsChannel, i.format, i.BatchSize, i.FlushInterval, i.flush)
+	}()
+	return nil
+}
+
+// the lines still buffered when stopping are lost
+func (i *InfluxdbProducer) Stop() error {
+	i.wait()
+	i.buffered = nil
+	return nil
+}
+
+// the tags of a metric in line protocol, the "routes:", "services:", "servers:" and "metrics:" tags map to
+// the route, service, server and metric tags, the tag naming the kind of proxy to the kind tag
+func (i *InfluxdbProducer) format(metric Metric) string {
+
+	tags := []string{}
+	kind := ""
+	name := ""
+	derived := false
+	for _, tag := range metric.Tags {
+		kv := strings.SplitN(tag, ":", 2)
+		switch {
+		case tag == "derived":
+			derived = true
+		case len(kv) == 2 && kv[0] == "routes":
+			tags = append(tags, "route="+escapeInfluxTag(kv[1]))
+		case len(kv) == 2 && kv[0] == "services":
+			tags = append(tags, "service="+escapeInfluxTag(kv[1]))
+		case len(kv) == 2 && kv[0] == "servers":
+			tags = append(tags, "server="+escapeInfluxTag(kv[1]))
+		case len(kv) == 2 && kv[0] == "metrics":
+			name = kv[1]
+		case len(kv) == 1 && len(kind) == 0:
+			kind = tag
+		}
+	}
+	if len(kind) > 0 {
+		tags = append(tags, "kind="+escapeInfluxTag(kind))
+	}
+	tags = append(tags, "metric="+escapeInfluxTag(name))
+
+	timestamp := time.Now()
+	if t, err := time.Parse(time.RFC3339, metric.Timestamp); err == nil {
+		timestamp = t
+	}
+
+	measurement := i.Measurement
+	value := strconv.FormatInt(int64(metric.Value), 10) + "i"
+	if derived {
+		measurement += "_derived"
+		value = formatValue(metric.Value)
+	}
+
+	return escapeInfluxMeasurement(measurement) + "," + strings.Join(tags, ",") + " value=" + value + " " + strconv.FormatInt(timestamp.Unix(), 10)
+}
+
+func (i *InfluxdbProducer) flush(lines []string) {
+
+	i.buffered = append(i.buffered, lines...)
+	if dropped := len(i.buffered) - i.MaxBuffered; dropped > 0 {
+		i.Log.Warning("Dropping %d metrics that could not be written to InfluxDB", dropped)
+		i.buffered = i.buffered[dropped:]
+		i.dropped(dropped)
+	}
+
+	if len(i.buffered) == 0 || time.Now().Before(i.nextWrite) {
+		return
+	}
+
+	for len(i.buffered) > 0 {
+
+		n := len(i.buffered)
+		if n > i.BatchSize {
+			n = i.BatchSize
+		}
+
+		retry, err := i.write(i.buffered[:n])
+		if err != nil && retry {
+			i.retryLater(err)
+			return
+		}
+		if err != nil {
+			i.Log.Error("Dropping %d metrics rejected by InfluxDB: %s", n, err.Error())
+			i.failed(err, true)
+			i.dropped(n)
+		} else {
+			i.produced(n)
+		}
+		i.buffered = i.buffered[n:]
+	}
+
+	i.buffered = nil
+	i.backoff = 0
+}
+
+// doubles the backoff and holds back writes until it passed
+func (i *InfluxdbProducer) retryLater(err error) {
+
+	switch {
+	case i.backoff == 0:
+		i.backoff = i.Backoff
+	case i.backoff*2 > i.MaxBackoff:
+		i.backoff = i.MaxBackoff
+	default:
+		i.backoff *= 2
+	}
+	i.nextWrite = time.Now().Add(i.backoff)
+	i.failed(err, false)
+	i.Log.Error("Error writing metrics to InfluxDB, retrying in %s: %s", i.backoff, err.Error())
+}
+
+// writes the lines, returns if the write should be retried when it failed
+func (i *InfluxdbProducer) write(lines []string) (bool, error) {
+
+	resp, err := i.Client.Post(i.URL, "text/plain; charset=utf-8", strings.NewReader(strings.Join(lines, "\n")+"\n"))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	err = errors.New(resp.Status + " " + strings.TrimSpace(string(body)))
+
+	// invalid lines are rejected with a 400, they will not be accepted on a retry either
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, err
+}
+
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+var influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+
+func escapeInfluxTag(value string) string {
+
+	// empty tag values are not allowed in line protocol
+	if len(value) == 0 {
+		return "none"
+	}
+	return influxTagEscaper.Replace(value)
+}
+
+func escapeInfluxMeasurement(measurement string) string {
+	return influxMeasurementEscaper.Replace(measurement)
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInfluxdbProducer_Format(t *testing.T) {
+
+	influxdb := NewInfluxdbProducer("http://localhost:8086/write?db=vamp&precision=s", testLog)
+	metric := Metric{[]string{"routes:r", "services:s a", "servers:x", "server", "metrics:scur"}, 3, "2015-02-24T18:45:07Z", "router-metric"}
+
+	expected := `vamp_router,route=r,service=s\ a,server=x,kind=server,metric=scur value=3i 1424803507`
+	if line := influxdb.format(metric); line != expected {
+		t.Errorf("Expected line %q, got %q", expected, line)
+	}
+
+	// derived metrics are floats, so they go to a measurement of their own
+	derived := Metric{[]string{"routes:r", "route", "derived", "metrics:hrsp_5xx_ratio"}, 0.25, "2015-02-24T18:45:07Z", "router-metric"}
+
+	expected = `vamp_router_derived,route=r,kind=route,metric=hrsp_5xx_ratio value=0.25 1424803507`
+	if line := influxdb.format(derived); line != expected {
+		t.Errorf("Expected line %q, got %q", expected, line)
+	}
+}
+
+func TestInfluxdbProducer_RetryWhileUnavailable(t *testing.T) {
+
+	var mutex sync.Mutex
+	writes := 0
+	received := make(chan string, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		// the first write fails, as if InfluxDB is unavailable
+		writes++
+		if writes == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	influxdb := NewInfluxdbProducer(server.URL+"/write?db=vamp", testLog)
+	influxdb.FlushInterval = 10 * time.Millisecond
+	influxdb.Backoff = 20 * time.Millisecond
+
+	metrics := make(chan Metric)
+	if err := influxdb.Start(metrics); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer influxdb.Stop()
+	defer close(metrics)
+
+	metrics <- Metric{[]string{"routes:r", "route", "metrics:rate"}, 5, "2015-02-24T18:45:07Z", "router-metric"}
+
+	select {
+	case body := <-received:
+		if expected := "vamp_router,route=r,kind=route,metric=rate value=5i 1424803507\n"; body != expected {
+			t.Errorf("Expected the buffered line %q, got %q", expected, body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the metric to be written after InfluxDB became available")
+	}
+}
@@ -1,63 +1,314 @@
 package metrics
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"github.com/Shopify/sarama"
 	gologger "github.com/op/go-logging"
-	"strconv"
+	"io/ioutil"
+	"regexp"
+	"strings"
 	"time"
 )
 
+/*
+  The KafkaProducer publishes the metrics stream to Kafka as JSON messages. Messages go to Topic, or with a
+  TopicTemplate to a topic per route, i.e. "loadbalancer.{route}". Metrics that belong to no route always go
+  to Topic. Messages are keyed by their route, so all metrics of a route end up in the same partition and
+  keep their order.
+
+  Messages are sent by an async producer, batched and compressed, with the protocol of the Kafka Version. When
+  the brokers cannot be reached, the producer reconnects after the ReconnectBackoff and the metrics arriving
+  meanwhile are dropped. Every connect retries fetching the metadata a few times, MetadataBackoff apart.
+*/
 type KafkaProducer struct {
+	Brokers          []string
+	Topic            string
+	TopicTemplate    string
+	Compression      string
+	Version          string
+	FlushFrequency   time.Duration
+	FlushMessages    int
+	ReconnectBackoff time.Duration
+	MetadataBackoff  time.Duration
+	TLS              bool
+	TLSCAFile        string
+	TLSCertFile      string
+	TLSKeyFile       string
+	SASLUser         string
+	SASLPassword     string
+	Log              *gologger.Logger
+
 	metricsChannel chan Metric
-	Log            *gologger.Logger
+	producerState
 }
 
-func (k *KafkaProducer) In(c chan Metric) {
-	k.metricsChannel = c
+var kafkaCompressions = map[string]sarama.CompressionCodec{
+	"none":   sarama.CompressionNone,
+	"gzip":   sarama.CompressionGZIP,
+	"snappy": sarama.CompressionSnappy,
+	"lz4":    sarama.CompressionLZ4,
 }
 
-func (k *KafkaProducer) Start(host string, port int) {
+// characters Kafka does not allow in a topic name
+var invalidTopicCharacters = regexp.MustCompile("[^a-zA-Z0-9._-]")
 
-	connection := host + ":" + strconv.Itoa(port)
+func NewKafkaProducer(brokers []string, log *gologger.Logger) *KafkaProducer {
+	return &KafkaProducer{
+		Brokers:          brokers,
+		Topic:            "loadbalancer.all",
+		Compression:      "snappy",
+		Version:          "1.0.0",
+		FlushFrequency:   500 * time.Millisecond,
+		FlushMessages:    100,
+		ReconnectBackoff: 10 * time.Second,
+		MetadataBackoff:  10 * time.Second,
+		Log:              log,
+	}
+}
 
-	k.Log.Notice("Connecting to Kafka on " + connection + "...")
+func (k *KafkaProducer) Name() string {
+	return "kafka"
+}
 
-	config := sarama.NewConfig()
-	config.Metadata.Retry.Backoff = (10 * time.Second)
+func (k *KafkaProducer) Start(c chan Metric) error {
+
+	if len(k.Brokers) == 0 {
+		return errors.New("no Kafka brokers configured")
+	}
+
+	config, err := k.config()
+	if err != nil {
+		k.failed(err, false)
+		return err
+	}
+
+	k.Log.Notice("Connecting to Kafka on " + strings.Join(k.Brokers, ",") + "...")
+
+	k.metricsChannel = c
+	k.started()
+	go k.produce(config)
+	return nil
+}
+
+func (k *KafkaProducer) Stop() error {
+	k.wait()
+	return nil
+}
 
-	/**
-	 *  Set producer config
-	 */
-	// don't use zip compression
-	config.Producer.Compression = 0
+func (k *KafkaProducer) config() (*sarama.Config, error) {
 
-	// We are just streaming metrics, so don't not wait for any Kafka Acks.
-	config.Producer.RequiredAcks = -1
+	config := sarama.NewConfig()
+	config.ClientID = "vamp-router"
+	config.Metadata.Retry.Backoff = k.MetadataBackoff
 
-	producer, err := sarama.NewSyncProducer([]string{connection}, config)
+	version, err := sarama.ParseKafkaVersion(k.Version)
 	if err != nil {
+		return nil, errors.New("unknown Kafka version: " + k.Version)
+	}
+	config.Version = version
 
-		k.Log.Error("Error connecting to Kafka: ", err.Error())
-	} else {
-		k.Log.Notice("Connection to Kafka successful")
+	compression, ok := kafkaCompressions[k.Compression]
+	if !ok {
+		return nil, errors.New("unknown Kafka compression: " + k.Compression)
 	}
+	config.Producer.Compression = compression
 
-	go k.produce(producer)
+	// We are just streaming metrics, so only wait for the leader to ack, not for all replicas.
+	config.Producer.RequiredAcks = sarama.WaitForLocal
+	config.Producer.Partitioner = sarama.NewHashPartitioner
+	config.Producer.Flush.Frequency = k.FlushFrequency
+	config.Producer.Flush.Messages = k.FlushMessages
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
 
+	if k.TLS {
+		tlsConfig, err := kafkaTLSConfig(k.TLSCAFile, k.TLSCertFile, k.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	if len(k.SASLUser) > 0 {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.User = k.SASLUser
+		config.Net.SASL.Password = k.SASLPassword
+	}
+
+	return config, config.Validate()
 }
 
-func (k *KafkaProducer) produce(producer sarama.SyncProducer) {
-	for {
-		metric := <-k.metricsChannel
-		json, err := json.MarshalIndent(metric, "", " ")
+// builds the TLS config from PEM files. Without a CA file the system roots are used, without a certificate
+// no client certificate is sent.
+func kafkaTLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+
+	tlsConfig := &tls.Config{}
+
+	if len(caFile) > 0 {
+		ca, err := ioutil.ReadFile(caFile)
 		if err != nil {
-			return
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no certificates found in " + caFile)
 		}
-		msg := &sarama.ProducerMessage{Topic: "loadbalancer.all", Value: sarama.StringEncoder(json)}
-		_, _, err = producer.SendMessage(msg)
+	}
+
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 		if err != nil {
-			k.Log.Error("error sending to Kafka ")
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// turns a metric into a message for the topic of its route, keyed by the route
+func (k *KafkaProducer) message(metric Metric) (*sarama.ProducerMessage, error) {
+
+	value, err := json.Marshal(metric)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &sarama.ProducerMessage{Topic: k.Topic, Value: sarama.ByteEncoder(value)}
+
+	for _, tag := range metric.Tags {
+		if strings.HasPrefix(tag, "routes:") {
+			route := strings.TrimPrefix(tag, "routes:")
+			msg.Key = sarama.StringEncoder(route)
+			if len(k.TopicTemplate) > 0 {
+				msg.Topic = strings.Replace(k.TopicTemplate, "{route}", invalidTopicCharacters.ReplaceAllString(route, "_"), -1)
+			}
+			break
+		}
+	}
+	return msg, nil
+}
+
+// produces until the metrics channel is closed, connecting and reconnecting to the brokers on the way
+func (k *KafkaProducer) produce(config *sarama.Config) {
+
+	defer k.stopped()
+
+	var producer sarama.AsyncProducer
+	var nextConnect time.Time
+
+	for {
+		if producer == nil && !time.Now().Before(nextConnect) {
+			var err error
+			if producer, err = sarama.NewAsyncProducer(k.Brokers, config); err != nil {
+				k.Log.Error("Error connecting to Kafka: " + err.Error())
+				k.failed(err, false)
+				producer = nil
+				nextConnect = time.Now().Add(k.ReconnectBackoff)
+			} else {
+				k.Log.Notice("Connection to Kafka successful")
+				k.produced(0)
+			}
+		}
+
+		var successes <-chan *sarama.ProducerMessage
+		var errs <-chan *sarama.ProducerError
+		if producer != nil {
+			successes = producer.Successes()
+			errs = producer.Errors()
+		}
+
+		select {
+		case metric, open := <-k.metricsChannel:
+			if !open {
+				if producer != nil {
+					k.close(producer)
+				}
+				return
+			}
+			if producer == nil {
+				k.dropped(1)
+				continue
+			}
+			msg, err := k.message(metric)
+			if err != nil {
+				k.dropped(1)
+				continue
+			}
+			if !k.send(producer, msg) {
+				k.close(producer)
+				producer = nil
+				nextConnect = time.Now().Add(k.ReconnectBackoff)
+			}
+
+		case <-successes:
+			k.produced(1)
+
+		case err := <-errs:
+			if k.producerError(err) {
+				k.close(producer)
+				producer = nil
+				nextConnect = time.Now().Add(k.ReconnectBackoff)
+			}
+		}
+	}
+}
+
+// hands a message to the producer, handling its results meanwhile so it never blocks on them. Returns
+// false when the producer lost all brokers and needs to reconnect.
+func (k *KafkaProducer) send(producer sarama.AsyncProducer, msg *sarama.ProducerMessage) bool {
+
+	for {
+		select {
+		case producer.Input() <- msg:
+			return true
+		case <-producer.Successes():
+			k.produced(1)
+		case err := <-producer.Errors():
+			if k.producerError(err) {
+				k.dropped(1)
+				return false
+			}
+		}
+	}
+}
+
+// records a failed message, returns true when the producer lost all brokers and needs to reconnect
+func (k *KafkaProducer) producerError(err *sarama.ProducerError) bool {
+
+	k.Log.Error("Error sending to Kafka: " + err.Error())
+	k.dropped(1)
+
+	lost := err.Err == sarama.ErrOutOfBrokers
+	k.failed(err, !lost)
+	return lost
+}
+
+// closes the producer, the messages it could not deliver anymore are dropped
+func (k *KafkaProducer) close(producer sarama.AsyncProducer) {
+
+	producer.AsyncClose()
+
+	successes, errs := producer.Successes(), producer.Errors()
+	for successes != nil || errs != nil {
+		select {
+		case _, open := <-successes:
+			if !open {
+				successes = nil
+				continue
+			}
+			k.produced(1)
+		case err, open := <-errs:
+			if !open {
+				errs = nil
+				continue
+			}
+			k.dropped(1)
+			k.failed(err, false)
 		}
 	}
 }
@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"github.com/Shopify/sarama"
+	"testing"
+	"time"
+)
+
+func TestKafkaProducer_Message(t *testing.T) {
+
+	kafka := NewKafkaProducer([]string{"localhost:9092"}, testLog)
+	kafka.TopicTemplate = "loadbalancer.{route}"
+
+	msg, err := kafka.message(Metric{[]string{"routes:r/1", "services:s", "service", "metrics:scur"}, 3, "2015-02-24T18:45:07Z", "router-metric"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if msg.Topic != "loadbalancer.r_1" {
+		t.Errorf("Expected the topic of the route, got %s", msg.Topic)
+	}
+	if key, _ := msg.Key.Encode(); string(key) != "r/1" {
+		t.Errorf("Expected the message to be keyed by the route, got %s", key)
+	}
+
+	// metrics of no route go to the default topic, without a key
+	msg, _ = kafka.message(Metric{[]string{"router", "haproxy", "metrics:restarts"}, 1, "2015-02-24T18:45:07Z", "router-metric"})
+	if msg.Topic != "loadbalancer.all" || msg.Key != nil {
+		t.Errorf("Expected an unkeyed message on the default topic, got %s", msg.Topic)
+	}
+}
+
+func TestKafkaProducer_Config(t *testing.T) {
+
+	kafka := NewKafkaProducer([]string{"localhost:9092"}, testLog)
+	kafka.Compression = "zip"
+
+	if err := kafka.Start(make(chan Metric)); err == nil {
+		t.Errorf("Expected an unknown compression to be refused")
+	}
+
+	kafka = NewKafkaProducer([]string{"localhost:9092"}, testLog)
+	kafka.Version = "1.0"
+
+	if err := kafka.Start(make(chan Metric)); err == nil {
+		t.Errorf("Expected an unknown version to be refused")
+	}
+
+	if err := NewKafkaProducer(nil, testLog).Start(make(chan Metric)); err == nil {
+		t.Errorf("Expected a producer without brokers to be refused")
+	}
+}
+
+// waits until the status of the producer matches, or fails the test after a few seconds
+func waitForKafkaStatus(t *testing.T, kafka *KafkaProducer, match func(ProducerStatus) bool) {
+
+	for i := 0; i < 200; i++ {
+		if match(kafka.Status()) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for the Kafka producer, status is %+v", kafka.Status())
+}
+
+func TestKafkaProducer_MockBroker(t *testing.T) {
+
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("loadbalancer.all", 0, broker.BrokerID()).
+			SetLeader("loadbalancer.r", 0, broker.BrokerID()),
+		// Kafka 1.0.0 is sent produce requests of version 3, the response has to match
+		"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3),
+	})
+
+	kafka := NewKafkaProducer([]string{broker.Addr()}, testLog)
+	kafka.TopicTemplate = "loadbalancer.{route}"
+	kafka.Compression = "none"
+	kafka.Version = "1.0.0"
+	kafka.FlushFrequency = 10 * time.Millisecond
+	kafka.MetadataBackoff = 10 * time.Millisecond
+
+	metrics := make(chan Metric)
+	if err := kafka.Start(metrics); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	metrics <- Metric{[]string{"routes:r", "route", "metrics:rate"}, 5, "2015-02-24T18:45:07Z", "router-metric"}
+	metrics <- Metric{[]string{"router", "haproxy", "metrics:restarts"}, 1, "2015-02-24T18:45:07Z", "router-metric"}
+
+	waitForKafkaStatus(t, kafka, func(s ProducerStatus) bool { return s.Connected && s.Produced == 2 })
+
+	close(metrics)
+	kafka.Stop()
+
+	if status := kafka.Status(); status.Dropped != 0 || status.Connected {
+		t.Errorf("Expected a stopped producer without dropped metrics, got %+v", status)
+	}
+}
+
+func TestKafkaProducer_Reconnect(t *testing.T) {
+
+	broker := sarama.NewMockBroker(t, 1)
+	addr := broker.Addr()
+
+	// the broker is gone before the producer connects
+	broker.Close()
+
+	kafka := NewKafkaProducer([]string{addr}, testLog)
+	kafka.Compression = "none"
+	kafka.ReconnectBackoff = 10 * time.Millisecond
+	kafka.MetadataBackoff = 10 * time.Millisecond
+
+	metrics := make(chan Metric)
+	if err := kafka.Start(metrics); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer kafka.Stop()
+	defer close(metrics)
+
+	metrics <- Metric{[]string{"routes:r", "route", "metrics:rate"}, 5, "2015-02-24T18:45:07Z", "router-metric"}
+
+	waitForKafkaStatus(t, kafka, func(s ProducerStatus) bool { return !s.Connected && len(s.LastError) > 0 && s.Dropped == 1 })
+}
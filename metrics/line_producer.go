@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the tags that only tell what kind of proxy a metric belongs to, they are implied by the path
+var proxyKindTags = map[string]bool{"route": true, "service": true, "server": true}
+
+/*
+  Turns the tags of a metric into a dotted path, as used by StatsD and Graphite. Tags like "routes:r" become
+  "routes.r", the "metrics:" tag ends the path and tags naming the kind of proxy are left out:
+
+      [routes:r services:s servers:x server metrics:scur] -> prefix.routes.r.services.s.servers.x.scur
+
+  Dots and other characters with a meaning in a path are replaced by underscores.
+*/
+func MetricPath(prefix string, tags []string) string {
+
+	path := []string{}
+	if len(prefix) > 0 {
+		path = append(path, strings.Trim(prefix, "."))
+	}
+
+	metric := ""
+	for _, tag := range tags {
+		kv := strings.SplitN(tag, ":", 2)
+		switch {
+		case len(kv) == 2 && kv[0] == "metrics":
+			metric = kv[1]
+		case len(kv) == 2:
+			path = append(path, sanitizePathElement(kv[0]), sanitizePathElement(kv[1]))
+		case !proxyKindTags[tag]:
+			path = append(path, sanitizePathElement(tag))
+		}
+	}
+
+	if len(metric) > 0 {
+		path = append(path, sanitizePathElement(metric))
+	}
+	return strings.Join(path, ".")
+}
+
+// gets the name of the metric from its "metrics:" tag
+func metricName(tags []string) string {
+
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, "metrics:") {
+			return strings.TrimPrefix(tag, "metrics:")
+		}
+	}
+	return ""
+}
+
+// formats a value without trailing zeroes, so counters look like integers
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+var pathElementReplacer = strings.NewReplacer(".", "_", " ", "_", ":", "_", "|", "_", "@", "_", "#", "_", ",", "_", "/", "_")
+
+func sanitizePathElement(element string) string {
+	return pathElementReplacer.Replace(element)
+}
+
+/*
+  Reads metrics from a channel and collects the lines they are formatted to in batches. A batch is flushed
+  when it holds batchSize lines, or when the interval passed since the last flush. Empty batches are flushed
+  as well, so producers holding back lines for an unavailable sink get the chance to retry.
+*/
+func batchLines(metricsChannel chan Metric, format func(Metric) string, batchSize int, interval time.Duration, flush func([]string)) {
+
+	batch := []string{}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case metric, open := <-metricsChannel:
+			if !open {
+				if len(batch) > 0 {
+					flush(batch)
+				}
+				return
+			}
+			batch = append(batch, format(metric))
+			if len(batch) >= batchSize {
+				flush(batch)
+				batch = []string{}
+			}
+		case <-ticker.C:
+			flush(batch)
+			batch = []string{}
+		}
+	}
+}
+
+/*
+  A connection that is dialed when first written to, and dropped when a write fails so the next write
+  dials again. Dialing is not retried before the backoff passed, so an unreachable endpoint does not stall
+  every flush.
+*/
+type reconnectingConn struct {
+	network  string
+	address  string
+	timeout  time.Duration
+	backoff  time.Duration
+	conn     net.Conn
+	nextDial time.Time
+	mutex    sync.Mutex
+}
+
+var errBackoff = errors.New("not reconnecting before the backoff passed")
+
+func newReconnectingConn(network string, address string) *reconnectingConn {
+	return &reconnectingConn{network: network, address: address, timeout: 5 * time.Second, backoff: 5 * time.Second}
+}
+
+func (r *reconnectingConn) Write(b []byte) (int, error) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.conn == nil {
+		if time.Now().Before(r.nextDial) {
+			return 0, errBackoff
+		}
+		conn, err := net.DialTimeout(r.network, r.address, r.timeout)
+		if err != nil {
+			r.nextDial = time.Now().Add(r.backoff)
+			return 0, err
+		}
+		r.conn = conn
+	}
+
+	r.conn.SetWriteDeadline(time.Now().Add(r.timeout))
+	n, err := r.conn.Write(b)
+	if err != nil {
+		r.conn.Close()
+		r.conn = nil
+	}
+	return n, err
+}
+
+func (r *reconnectingConn) Close() error {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"bufio"
+	gologger "github.com/op/go-logging"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testLog = gologger.MustGetLogger("vamp-router")
+
+func TestLineProducer_MetricPath(t *testing.T) {
+
+	tests := []struct {
+		tags     []string
+		expected string
+	}{
+		{[]string{"routes:r", "route", "metrics:scur"}, "vamp.routes.r.scur"},
+		{[]string{"routes:r", "services:s", "servers:x.y", "server", "metrics:scur"}, "vamp.routes.r.services.s.servers.x_y.scur"},
+		{[]string{"routes:r", "services:s", "mirror", "metrics:rate"}, "vamp.routes.r.services.s.mirror.rate"},
+		{[]string{"router", "haproxy", "metrics:restarts"}, "vamp.router.haproxy.restarts"},
+	}
+
+	for _, test := range tests {
+		if path := MetricPath("vamp.", test.tags); path != test.expected {
+			t.Errorf("Expected path %s, got %s", test.expected, path)
+		}
+	}
+}
+
+func TestLineProducer_Statsd(t *testing.T) {
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer listener.Close()
+
+	statsd := NewStatsdProducer("127.0.0.1", listener.LocalAddr().(*net.UDPAddr).Port, "vamp", testLog)
+	statsd.DogStatsd = true
+	statsd.FlushInterval = 10 * time.Millisecond
+
+	metrics := make(chan Metric)
+	if err := statsd.Start(metrics); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer statsd.Stop()
+	defer close(metrics)
+
+	metrics <- Metric{[]string{"routes:r", "services:s", "service", "metrics:scur"}, 3, "2015-02-24T18:45:07Z", "router-metric"}
+	metrics <- Metric{[]string{"routes:r", "route", "metrics:rate"}, 5, "2015-02-24T18:45:07Z", "router-metric"}
+
+	listener.SetDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := listener.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := "vamp.scur:3|g|#routes:r,services:s,service\nvamp.rate:5|g|#routes:r,route"
+	if packet := string(buf[:n]); packet != expected {
+		t.Errorf("Expected packet %q, got %q", expected, packet)
+	}
+}
+
+func TestLineProducer_Graphite(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer listener.Close()
+
+	graphite := NewGraphiteProducer("127.0.0.1", listener.Addr().(*net.TCPAddr).Port, "vamp", testLog)
+	graphite.FlushInterval = 10 * time.Millisecond
+
+	metrics := make(chan Metric)
+	if err := graphite.Start(metrics); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	metrics <- Metric{[]string{"routes:r", "services:s", "servers:x", "server", "metrics:scur"}, 3, "2015-02-24T18:45:07Z", "router-metric"}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if expected := "vamp.routes.r.services.s.servers.x.scur 3 1424803507"; strings.TrimSpace(line) != expected {
+		t.Errorf("Expected line %q, got %q", expected, line)
+	}
+}
+
+func TestLineProducer_GraphiteWrittenLines(t *testing.T) {
+
+	lines := []string{"a 1 0", "b 2 0", "c 3 0"}
+
+	// a write stopping in the middle of the second line only sent the first one completely
+	if written := writtenLines(lines, 8); written != 1 {
+		t.Errorf("Expected 1 written line, got %d", written)
+	}
+	if written := writtenLines(lines, 12); written != 2 {
+		t.Errorf("Expected 2 written lines, got %d", written)
+	}
+	if written := writtenLines(lines, 0); written != 0 {
+		t.Errorf("Expected no written lines, got %d", written)
+	}
+}
@@ -1,11 +1,107 @@
 package metrics
 
+import (
+	"sync"
+	"time"
+)
 
-// 
+/*
+  A MetricsProducer sends the metrics stream to an external sink. Producers are added to the
+  ProducerRegistry, which starts and stops them when they are enabled or disabled:
+
+  - Start gets the channel the metrics arrive on and starts producing in the background. It returns an
+    error when the producer cannot start at all, i.e. on an invalid configuration.
+  - Stop is called after the channel was closed. It returns when the producer stopped and released its
+    connections.
+  - Status reports the health of the producer.
+*/
 type MetricsProducer interface {
+	Name() string
+	Start(c chan Metric) error
+	Stop() error
+	Status() ProducerStatus
+}
+
+// The status of a producer. The registry fills in the name and whether it is enabled.
+type ProducerStatus struct {
+	Name          string     `json:"name"`
+	Enabled       bool       `json:"enabled"`
+	Connected     bool       `json:"connected"`
+	Produced      int64      `json:"produced"`
+	Dropped       int64      `json:"dropped"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorTime *time.Time `json:"lastErrorTime,omitempty"`
+}
+
+/*
+  Keeps the status of a producer and the channel that is closed when it stopped producing. Producers embed
+  it to implement Status.
+*/
+type producerState struct {
+	status ProducerStatus
+	done   chan struct{}
+	mutex  sync.Mutex
+}
+
+func (p *producerState) Status() ProducerStatus {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.status
+}
+
+// marks the start of producing, the done channel is closed by stopped
+func (p *producerState) started() {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.done = make(chan struct{})
+}
+
+func (p *producerState) stopped() {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.status.Connected = false
+	if p.done != nil {
+		close(p.done)
+	}
+}
+
+// waits until the producer stopped producing
+func (p *producerState) wait() {
+
+	p.mutex.Lock()
+	done := p.done
+	p.mutex.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+func (p *producerState) produced(n int) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.status.Connected = true
+	p.status.Produced += int64(n)
+}
+
+func (p *producerState) dropped(n int) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.status.Dropped += int64(n)
+}
 
-  Consume(c chan Metric)
-  Init()
-  Produce()
+// records an error of the sink, connected tells if the sink could still be reached
+func (p *producerState) failed(err error, connected bool) {
 
-}
\ No newline at end of file
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := time.Now()
+	p.status.Connected = connected
+	p.status.LastError = err.Error()
+	p.status.LastErrorTime = &now
+}
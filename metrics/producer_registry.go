@@ -0,0 +1,257 @@
+package metrics
+
+import (
+	"errors"
+	gologger "github.com/op/go-logging"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrNoSuchProducer        = errors.New("no such producer")
+	ErrProducerAlreadyExists = errors.New("producer already exists")
+)
+
+/*
+The ProducerRegistry keeps the producers by name and fans out the metrics stream to the enabled ones.
+Every enabled producer gets its own buffered channel. When a producer cannot keep up and its channel is
+full, metrics are dropped for that producer only, so a slow sink never blocks the stream.
+*/
+type ProducerRegistry struct {
+	MetricsChannel chan Metric
+	BufferSize     int
+	Log            *gologger.Logger
+
+	producers map[string]*registeredProducer
+	mutex     sync.RWMutex
+
+	// serializes enabling and disabling, which start and stop producers outside of the mutex
+	changes sync.Mutex
+}
+
+type registeredProducer struct {
+	producer MetricsProducer
+	channel  chan Metric
+	dropped  int64
+}
+
+func NewProducerRegistry(metricsChannel chan Metric, log *gologger.Logger) *ProducerRegistry {
+	return &ProducerRegistry{
+		MetricsChannel: metricsChannel,
+		BufferSize:     10000,
+		Log:            log,
+		producers:      make(map[string]*registeredProducer),
+	}
+}
+
+// Dispatches the metrics stream to the enabled producers, until the metrics channel is closed
+func (r *ProducerRegistry) Start() {
+
+	for metric := range r.MetricsChannel {
+
+		r.mutex.Lock()
+		for _, p := range r.producers {
+			if p.channel == nil {
+				continue
+			}
+			select {
+			case p.channel <- metric:
+			default:
+				p.dropped++
+			}
+		}
+		r.mutex.Unlock()
+	}
+}
+
+// adds a producer, it is started when it is enabled
+func (r *ProducerRegistry) Register(producer MetricsProducer) error {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.producers[producer.Name()]; ok {
+		return ErrProducerAlreadyExists
+	}
+	r.producers[producer.Name()] = &registeredProducer{producer: producer}
+	return nil
+}
+
+// starts a producer, enabling an enabled producer does nothing
+func (r *ProducerRegistry) Enable(name string) error {
+
+	r.changes.Lock()
+	defer r.changes.Unlock()
+
+	r.mutex.RLock()
+	p, ok := r.producers[name]
+	enabled := ok && p.channel != nil
+	r.mutex.RUnlock()
+
+	if !ok {
+		return ErrNoSuchProducer
+	}
+	if enabled {
+		return nil
+	}
+
+	// connecting may take a while, the stream keeps flowing to the other producers meanwhile
+	channel := make(chan Metric, r.BufferSize)
+	if err := p.producer.Start(channel); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	p.channel = channel
+	r.mutex.Unlock()
+
+	r.Log.Notice("Enabled metrics producer " + name)
+	return nil
+}
+
+// stops a producer, disabling a disabled producer does nothing
+func (r *ProducerRegistry) Disable(name string) error {
+
+	r.changes.Lock()
+	defer r.changes.Unlock()
+
+	r.mutex.Lock()
+	p, ok := r.producers[name]
+	if !ok {
+		r.mutex.Unlock()
+		return ErrNoSuchProducer
+	}
+	channel := p.channel
+	p.channel = nil
+	r.mutex.Unlock()
+
+	if channel == nil {
+		return nil
+	}
+
+	// the producer is stopped outside of the mutex, so the stream keeps flowing to the others meanwhile
+	close(channel)
+	r.Log.Notice("Disabled metrics producer " + name)
+	return p.producer.Stop()
+}
+
+// stops all producers
+func (r *ProducerRegistry) Stop() {
+
+	for _, status := range r.Status() {
+		if err := r.Disable(status.Name); err != nil {
+			r.Log.Error("Error stopping metrics producer " + status.Name + ": " + err.Error())
+		}
+	}
+}
+
+func (r *ProducerRegistry) Get(name string) (ProducerStatus, error) {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	p, ok := r.producers[name]
+	if !ok {
+		return ProducerStatus{}, ErrNoSuchProducer
+	}
+	return p.status(), nil
+}
+
+// gets the status of all producers, sorted by name
+func (r *ProducerRegistry) Status() []ProducerStatus {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	names := []string{}
+	for name := range r.producers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	statuses := []ProducerStatus{}
+	for _, name := range names {
+		statuses = append(statuses, r.producers[name].status())
+	}
+	return statuses
+}
+
+// the status of the producer, with the metrics dropped because it could not keep up
+func (p *registeredProducer) status() ProducerStatus {
+
+	status := p.producer.Status()
+	status.Name = p.producer.Name()
+	status.Enabled = p.channel != nil
+	status.Dropped += p.dropped
+	return status
+}
+
+// The settings of the producers, as set by the flags or environment variables of the router
+type ProducerSettings struct {
+	KafkaBrokers       []string
+	KafkaTopic         string
+	KafkaTopicTemplate string
+	KafkaCompression   string
+	KafkaVersion       string
+	KafkaTLS           bool
+	KafkaTLSCAFile     string
+	KafkaTLSCertFile   string
+	KafkaTLSKeyFile    string
+	KafkaSASLUser      string
+	KafkaSASLPassword  string
+	StatsdHost         string
+	StatsdPort         int
+	StatsdPrefix       string
+	DogStatsd          bool
+	GraphiteHost       string
+	GraphitePort       int
+	GraphitePrefix     string
+	InfluxdbUrl        string
+}
+
+/*
+Registers all producers the router knows. The ones with a host or URL in the settings are enabled, the
+others are registered disabled so they are listed, but cannot be enabled without a host.
+*/
+func (r *ProducerRegistry) Configure(settings ProducerSettings) {
+
+	statsd := NewStatsdProducer(settings.StatsdHost, settings.StatsdPort, settings.StatsdPrefix, r.Log)
+	statsd.DogStatsd = settings.DogStatsd
+
+	kafka := NewKafkaProducer(settings.KafkaBrokers, r.Log)
+	if len(settings.KafkaTopic) > 0 {
+		kafka.Topic = settings.KafkaTopic
+	}
+	if len(settings.KafkaCompression) > 0 {
+		kafka.Compression = settings.KafkaCompression
+	}
+	if len(settings.KafkaVersion) > 0 {
+		kafka.Version = settings.KafkaVersion
+	}
+	kafka.TopicTemplate = settings.KafkaTopicTemplate
+	kafka.TLS = settings.KafkaTLS
+	kafka.TLSCAFile = settings.KafkaTLSCAFile
+	kafka.TLSCertFile = settings.KafkaTLSCertFile
+	kafka.TLSKeyFile = settings.KafkaTLSKeyFile
+	kafka.SASLUser = settings.KafkaSASLUser
+	kafka.SASLPassword = settings.KafkaSASLPassword
+
+	r.configure(kafka, len(settings.KafkaBrokers) > 0)
+	r.configure(statsd, len(settings.StatsdHost) > 0)
+	r.configure(NewGraphiteProducer(settings.GraphiteHost, settings.GraphitePort, settings.GraphitePrefix, r.Log), len(settings.GraphiteHost) > 0)
+	r.configure(NewInfluxdbProducer(settings.InfluxdbUrl, r.Log), len(settings.InfluxdbUrl) > 0)
+	r.configure(&SimpleProducer{}, false)
+}
+
+func (r *ProducerRegistry) configure(producer MetricsProducer, enabled bool) {
+
+	if err := r.Register(producer); err != nil {
+		r.Log.Error("Error registering metrics producer " + producer.Name() + ": " + err.Error())
+		return
+	}
+	if enabled {
+		if err := r.Enable(producer.Name()); err != nil {
+			r.Log.Error("Error enabling metrics producer " + producer.Name() + ": " + err.Error())
+		}
+	}
+}
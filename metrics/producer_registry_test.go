@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+// a producer that hands the metrics to the test
+type testProducer struct {
+	received chan Metric
+	producerState
+}
+
+func (p *testProducer) Name() string {
+	return "test"
+}
+
+func (p *testProducer) Start(c chan Metric) error {
+	p.started()
+	go func() {
+		defer p.stopped()
+		for metric := range c {
+			p.received <- metric
+			p.produced(1)
+		}
+	}()
+	return nil
+}
+
+func (p *testProducer) Stop() error {
+	p.wait()
+	return nil
+}
+
+func TestProducerRegistry_EnableDisable(t *testing.T) {
+
+	metrics := make(chan Metric)
+	registry := NewProducerRegistry(metrics, testLog)
+	go registry.Start()
+	defer close(metrics)
+
+	producer := &testProducer{received: make(chan Metric, 10)}
+	if err := registry.Register(producer); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := registry.Register(&testProducer{}); err != ErrProducerAlreadyExists {
+		t.Errorf("Expected a second producer with the same name to be refused")
+	}
+	if err := registry.Enable("unknown"); err != ErrNoSuchProducer {
+		t.Errorf("Expected enabling an unknown producer to fail")
+	}
+
+	// disabled producers get no metrics
+	metrics <- Metric{[]string{"metrics:scur"}, 1, "", "router-metric"}
+
+	if err := registry.Enable("test"); err != nil {
+		t.Fatal(err.Error())
+	}
+	metrics <- Metric{[]string{"metrics:scur"}, 2, "", "router-metric"}
+
+	select {
+	case metric := <-producer.received:
+		if metric.Value != 2 {
+			t.Errorf("Expected only the metric sent after enabling, got value %v", metric.Value)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the enabled producer to get the metric")
+	}
+
+	if err := registry.Disable("test"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	status, err := registry.Get("test")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if status.Enabled || status.Connected || status.Produced != 1 {
+		t.Errorf("Expected a disabled producer that produced 1 metric, got %+v", status)
+	}
+}
+
+func TestProducerRegistry_DropWhenFull(t *testing.T) {
+
+	metrics := make(chan Metric)
+	registry := NewProducerRegistry(metrics, testLog)
+	registry.BufferSize = 1
+	go registry.Start()
+	defer close(metrics)
+
+	// the producer takes one metric and blocks on handing it over, so its channel fills up
+	producer := &testProducer{received: make(chan Metric)}
+	registry.Register(producer)
+	registry.Enable("test")
+
+	for i := 0; i < 5; i++ {
+		metrics <- Metric{[]string{"metrics:scur"}, float64(i), "", "router-metric"}
+	}
+
+	// the metrics channel is unbuffered, sending one more makes sure the last one was dispatched
+	metrics <- Metric{[]string{"metrics:scur"}, 5, "", "router-metric"}
+
+	status, _ := registry.Get("test")
+	if status.Dropped < 3 {
+		t.Errorf("Expected the metrics not fitting the channel to be dropped, got %d dropped", status.Dropped)
+	}
+}
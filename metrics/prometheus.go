@@ -0,0 +1,395 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/magneticio/vamp-router/haproxy"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+  The PrometheusExporter writes the metrics of the running Haproxy and of vamp-router itself in the
+  Prometheus text exposition format (version 0.0.4).
+
+  Every Haproxy proxy gets route, service and server labels derived from its name, in the same way the
+  metrics stream tags them, and a kind label of "route", "service", "server" or "mirror". Proxies the metrics
+  stream leaves out, like the socket servers of a route backend, are left out here as well.
+*/
+type PrometheusExporter struct {
+	Runtime   haproxy.RuntimeProvider
+	Config    *haproxy.Config
+	SSEBroker *SSEBroker
+	Requests  *RequestHistogram
+}
+
+// a metric family taken from a column of "show stat"
+type prometheusStat struct {
+	column string
+	name   string
+	kind   string
+	help   string
+	scale  float64
+}
+
+var prometheusStats = []prometheusStat{
+	{"scur", "haproxy_current_sessions", "gauge", "Current number of sessions.", 1},
+	{"smax", "haproxy_max_sessions", "gauge", "Maximum observed number of sessions.", 1},
+	{"slim", "haproxy_limit_sessions", "gauge", "Configured session limit.", 1},
+	{"stot", "haproxy_sessions_total", "counter", "Total number of sessions.", 1},
+	{"rate", "haproxy_current_session_rate", "gauge", "Number of sessions per second over the last second.", 1},
+	{"rate_max", "haproxy_max_session_rate", "gauge", "Maximum observed number of sessions per second.", 1},
+	{"qcur", "haproxy_current_queue", "gauge", "Current number of queued requests.", 1},
+	{"qmax", "haproxy_max_queue", "gauge", "Maximum observed number of queued requests.", 1},
+	{"bin", "haproxy_bytes_in_total", "counter", "Total number of bytes received.", 1},
+	{"bout", "haproxy_bytes_out_total", "counter", "Total number of bytes sent.", 1},
+	{"dreq", "haproxy_requests_denied_total", "counter", "Total number of denied requests.", 1},
+	{"dresp", "haproxy_responses_denied_total", "counter", "Total number of denied responses.", 1},
+	{"ereq", "haproxy_request_errors_total", "counter", "Total number of request errors.", 1},
+	{"econ", "haproxy_connection_errors_total", "counter", "Total number of connection errors.", 1},
+	{"eresp", "haproxy_response_errors_total", "counter", "Total number of response errors.", 1},
+	{"wretr", "haproxy_retry_warnings_total", "counter", "Total number of connection retries.", 1},
+	{"wredis", "haproxy_redispatch_warnings_total", "counter", "Total number of redispatches.", 1},
+	{"cli_abrt", "haproxy_client_aborts_total", "counter", "Total number of transfers aborted by the client.", 1},
+	{"srv_abrt", "haproxy_server_aborts_total", "counter", "Total number of transfers aborted by the server.", 1},
+	{"req_rate", "haproxy_current_request_rate", "gauge", "Number of HTTP requests per second over the last second.", 1},
+	{"req_rate_max", "haproxy_max_request_rate", "gauge", "Maximum observed number of HTTP requests per second.", 1},
+	{"req_tot", "haproxy_http_requests_total", "counter", "Total number of HTTP requests.", 1},
+	{"weight", "haproxy_weight", "gauge", "Weight of a server, or the total weight of a backend.", 1},
+	{"chkfail", "haproxy_check_failures_total", "counter", "Total number of failed health checks.", 1},
+	{"downtime", "haproxy_downtime_seconds_total", "counter", "Total downtime in seconds.", 1},
+	{"qtime", "haproxy_queue_time_average_seconds", "gauge", "Average queue time of the last 1024 requests.", 0.001},
+	{"ctime", "haproxy_connect_time_average_seconds", "gauge", "Average connect time of the last 1024 requests.", 0.001},
+	{"rtime", "haproxy_response_time_average_seconds", "gauge", "Average response time of the last 1024 requests.", 0.001},
+	{"ttime", "haproxy_total_time_average_seconds", "gauge", "Average total time of the last 1024 requests.", 0.001},
+}
+
+// the response code classes of the "hrsp_*" columns
+var prometheusResponseCodes = []string{"1xx", "2xx", "3xx", "4xx", "5xx", "other"}
+
+// a metric family taken from a line of "show info"
+type prometheusInfo struct {
+	value func(info haproxy.Info) string
+	name  string
+	kind  string
+	help  string
+}
+
+var prometheusInfos = []prometheusInfo{
+	{func(i haproxy.Info) string { return i.Uptime_sec }, "haproxy_process_uptime_seconds", "gauge", "Uptime of the Haproxy process in seconds."},
+	{func(i haproxy.Info) string { return i.Maxconn }, "haproxy_process_max_connections", "gauge", "Maximum number of concurrent connections."},
+	{func(i haproxy.Info) string { return i.CurrConns }, "haproxy_process_current_connections", "gauge", "Current number of connections."},
+	{func(i haproxy.Info) string { return i.CumConns }, "haproxy_process_connections_total", "counter", "Total number of connections."},
+	{func(i haproxy.Info) string { return i.CumReq }, "haproxy_process_requests_total", "counter", "Total number of requests."},
+}
+
+/*
+  Writes all metrics to w. The caller holds the read lock of the config, as the reload counters and the
+  config revision are read from it.
+*/
+func (e *PrometheusExporter) Write(w io.Writer) error {
+
+	p := &prometheusWriter{w: bufio.NewWriter(w)}
+
+	e.writeHaproxyInfo(p)
+	e.writeHaproxyStats(p)
+	e.writeRouterMetrics(p)
+
+	if p.err != nil {
+		return p.err
+	}
+	return p.w.Flush()
+}
+
+func (e *PrometheusExporter) writeHaproxyInfo(p *prometheusWriter) {
+
+	info, err := e.Runtime.GetInfo()
+
+	p.family("haproxy_up", "gauge", "Whether the Haproxy process is reachable on its stats socket.")
+	if err != nil {
+		p.sample("haproxy_up", nil, 0)
+		return
+	}
+	p.sample("haproxy_up", nil, 1)
+
+	p.family("haproxy_process_info", "gauge", "Version of the Haproxy process.")
+	p.sample("haproxy_process_info", []string{"version", info.Version}, 1)
+
+	for _, i := range prometheusInfos {
+		if value, err := strconv.ParseFloat(i.value(info), 64); err == nil {
+			p.family(i.name, i.kind, i.help)
+			p.sample(i.name, nil, value)
+		}
+	}
+}
+
+func (e *PrometheusExporter) writeHaproxyStats(p *prometheusWriter) {
+
+	stats, err := e.Runtime.GetStats("all")
+	if err != nil {
+		return
+	}
+
+	// sorted, so the output is stable between scrapes
+	var slots map[string]string
+	if e.Config != nil {
+		slots = e.Config.SlotServers()
+	}
+
+	keys := []string{}
+	labels := make(map[string][]string)
+	for key, proxy := range stats {
+		if l := proxyLabels(proxy["pxname"], proxy["svname"], slots); l != nil {
+			keys = append(keys, key)
+			labels[key] = l
+		}
+	}
+	sort.Strings(keys)
+
+	for _, s := range prometheusStats {
+		p.family(s.name, s.kind, s.help)
+		for _, key := range keys {
+			if value, err := strconv.ParseFloat(stats[key][s.column], 64); err == nil {
+				p.sample(s.name, labels[key], value*s.scale)
+			}
+		}
+	}
+
+	p.family("haproxy_http_responses_total", "counter", "Total number of HTTP responses by status code class.")
+	for _, key := range keys {
+		for _, code := range prometheusResponseCodes {
+			if value, err := strconv.ParseFloat(stats[key]["hrsp_"+code], 64); err == nil {
+				p.sample("haproxy_http_responses_total", append(labels[key], "code", code), value)
+			}
+		}
+	}
+
+	p.family("haproxy_proxy_up", "gauge", "Whether a proxy is up, open or not checked.")
+	for _, key := range keys {
+		if status := stats[key]["status"]; len(status) > 0 {
+			p.sample("haproxy_proxy_up", labels[key], proxyUp(status))
+		}
+	}
+}
+
+func (e *PrometheusExporter) writeRouterMetrics(p *prometheusWriter) {
+
+	status := e.Runtime.Status(e.Config)
+
+	p.family("vamp_router_reloads_total", "counter", "Total number of successful Haproxy reloads.")
+	p.sample("vamp_router_reloads_total", nil, float64(status.Reloads))
+
+	p.family("vamp_router_reload_duration_seconds_total", "counter", "Total time spent reloading Haproxy.")
+	p.sample("vamp_router_reload_duration_seconds_total", nil, status.ReloadTime.Seconds())
+
+	if status.Reload != nil {
+		if d, err := time.ParseDuration(status.Reload.Duration); err == nil {
+			p.family("vamp_router_last_reload_duration_seconds", "gauge", "Duration of the last Haproxy reload.")
+			p.sample("vamp_router_last_reload_duration_seconds", nil, d.Seconds())
+		}
+	}
+
+	p.family("vamp_router_config_revision", "gauge", "Revision of the persisted config, raised on every change.")
+	p.sample("vamp_router_config_revision", nil, float64(e.Config.Revision))
+
+	if e.SSEBroker != nil {
+		p.family("vamp_router_sse_clients", "gauge", "Number of clients attached to the metrics stream.")
+		p.sample("vamp_router_sse_clients", nil, float64(e.SSEBroker.ClientCount()))
+
+		p.family("vamp_router_sse_dropped_total", "counter", "Total number of events dropped for slow stream clients.")
+		p.sample("vamp_router_sse_dropped_total", nil, float64(e.SSEBroker.Dropped()))
+	}
+
+	if e.Requests != nil {
+		e.Requests.write(p, "vamp_router_api_request_duration_seconds")
+	}
+}
+
+/*
+  Maps the tags of a proxy to Prometheus labels, as pairs of name and value. Returns nil for proxies whose
+  metrics are not exported.
+*/
+func proxyLabels(pxname string, svname string, slots map[string]string) []string {
+
+	tags := ProxyTags(pxname, svname, slots)
+	if tags == nil {
+		return nil
+	}
+
+	labels := []string{"route", "", "service", "", "server", "", "kind", "", "proxy", strings.ToLower(svname)}
+	for _, tag := range tags {
+		switch {
+		case strings.HasPrefix(tag, "routes:"):
+			labels[1] = strings.TrimPrefix(tag, "routes:")
+		case strings.HasPrefix(tag, "services:"):
+			labels[3] = strings.TrimPrefix(tag, "services:")
+		case strings.HasPrefix(tag, "servers:"):
+			labels[5] = strings.TrimPrefix(tag, "servers:")
+		default:
+			labels[7] = tag
+		}
+	}
+
+	// servers are named by the server label already
+	if labels[9] != "frontend" && labels[9] != "backend" {
+		labels[9] = "server"
+	}
+	return labels
+}
+
+func proxyUp(status string) float64 {
+
+	switch s := strings.Fields(status); {
+	case len(s) == 0:
+		return 0
+	case s[0] == "UP", s[0] == "OPEN", status == "no check":
+		return 1
+	}
+	return 0
+}
+
+/*
+  The RequestHistogram keeps the latency of API requests by method and status code, with cumulative buckets
+  as Prometheus expects them.
+*/
+type RequestHistogram struct {
+	Buckets []float64
+	series  map[requestSeries]*requestBuckets
+	mutex   sync.Mutex
+}
+
+type requestSeries struct {
+	method string
+	code   int
+}
+
+type requestBuckets struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func NewRequestHistogram() *RequestHistogram {
+	return &RequestHistogram{
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		series:  make(map[requestSeries]*requestBuckets),
+	}
+}
+
+// records the latency of one request
+func (h *RequestHistogram) Observe(method string, code int, latency time.Duration) {
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	key := requestSeries{method, code}
+	b, ok := h.series[key]
+	if !ok {
+		b = &requestBuckets{counts: make([]uint64, len(h.Buckets))}
+		h.series[key] = b
+	}
+
+	seconds := latency.Seconds()
+	for i, bound := range h.Buckets {
+		if seconds <= bound {
+			b.counts[i]++
+		}
+	}
+	b.sum += seconds
+	b.count++
+}
+
+func (h *RequestHistogram) write(p *prometheusWriter, name string) {
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	keys := []requestSeries{}
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Sort(requestSeriesByLabels(keys))
+
+	p.family(name, "histogram", "Latency of the requests to the REST API.")
+	for _, key := range keys {
+		b := h.series[key]
+		labels := []string{"method", key.method, "code", strconv.Itoa(key.code)}
+		for i, bound := range h.Buckets {
+			p.sample(name+"_bucket", append(labels, "le", formatFloat(bound)), float64(b.counts[i]))
+		}
+		p.sample(name+"_bucket", append(labels, "le", "+Inf"), float64(b.count))
+		p.sample(name+"_sum", labels, b.sum)
+		p.sample(name+"_count", labels, float64(b.count))
+	}
+}
+
+type requestSeriesByLabels []requestSeries
+
+func (s requestSeriesByLabels) Len() int      { return len(s) }
+func (s requestSeriesByLabels) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s requestSeriesByLabels) Less(i, j int) bool {
+	if s[i].method != s[j].method {
+		return s[i].method < s[j].method
+	}
+	return s[i].code < s[j].code
+}
+
+// writes the text format, keeping the first error
+type prometheusWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (p *prometheusWriter) family(name string, kind string, help string) {
+	p.printf("# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+}
+
+// writes a sample, the labels are pairs of name and value
+func (p *prometheusWriter) sample(name string, labels []string, value float64) {
+
+	pairs := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+escapeLabelValue(labels[i+1])+`"`)
+	}
+
+	if len(pairs) > 0 {
+		p.printf("%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(value))
+	} else {
+		p.printf("%s %s\n", name, formatFloat(value))
+	}
+}
+
+func (p *prometheusWriter) printf(format string, a ...interface{}) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, a...)
+	}
+}
+
+func formatFloat(f float64) string {
+
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
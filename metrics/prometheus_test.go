@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/magneticio/vamp-router/haproxy"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheus_Write(t *testing.T) {
+
+	conf := &haproxy.Config{WorkingDir: "/tmp"}
+	conf.InitializeConfig()
+
+	route := haproxy.Route{
+		Name:     "prom_route",
+		Port:     9038,
+		Protocol: "http",
+		Services: []*haproxy.Service{
+			&haproxy.Service{Name: "service_a", Weight: 100, Servers: []*haproxy.Server{
+				&haproxy.Server{Name: "server_a", Host: "192.168.2.2", Port: 8081},
+			}},
+		},
+	}
+	if err := conf.AddRoute(route); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	runtime, err := haproxy.NewFakeRuntime("/tmp/vamp_prometheus_test.sock")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer runtime.Close()
+
+	if err := runtime.Reload(conf); err != nil {
+		t.Fatal(err.Error())
+	}
+	backendName := haproxy.BackendName("prom_route", "service_a")
+	runtime.Socket.SetCounter(backendName, "server_a", "stot", 42)
+	runtime.Socket.SetCounter(backendName, "server_a", "rtime", 250)
+
+	requests := NewRequestHistogram()
+	requests.Observe("GET", 200, 20*time.Millisecond)
+
+	exporter := &PrometheusExporter{Runtime: runtime, Config: conf, Requests: requests}
+
+	var buf bytes.Buffer
+	if err := exporter.Write(&buf); err != nil {
+		t.Fatal(err.Error())
+	}
+	output := buf.String()
+
+	expected := []string{
+		"# TYPE haproxy_sessions_total counter\n",
+		"# TYPE haproxy_current_sessions gauge\n",
+		`haproxy_sessions_total{route="prom_route",service="service_a",server="server_a",kind="server",proxy="server"} 42`,
+		`haproxy_response_time_average_seconds{route="prom_route",service="service_a",server="server_a",kind="server",proxy="server"} 0.25`,
+		"vamp_router_reloads_total 1\n",
+		"# TYPE vamp_router_api_request_duration_seconds histogram\n",
+		`vamp_router_api_request_duration_seconds_bucket{method="GET",code="200",le="0.01"} 0`,
+		`vamp_router_api_request_duration_seconds_bucket{method="GET",code="200",le="0.025"} 1`,
+		`vamp_router_api_request_duration_seconds_count{method="GET",code="200"} 1`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(output, e) {
+			t.Errorf("Expected the output to contain %q", e)
+		}
+	}
+
+	// the socket servers of the route backend are no route, service or server
+	if strings.Contains(output, `server="prom_route::service_a"`) {
+		t.Errorf("Expected no metrics of the socket servers")
+	}
+}
+
+func TestPrometheus_EscapeLabelValue(t *testing.T) {
+
+	if escaped := escapeLabelValue("a\"b\\c\nd"); escaped != `a\"b\\c\nd` {
+		t.Errorf("Expected label value to be escaped, got %s", escaped)
+	}
+}
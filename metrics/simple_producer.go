@@ -1,31 +1,43 @@
 package metrics
 
 import (
-  "fmt"
-  "encoding/json"
+	"encoding/json"
+	"fmt"
 )
 
 //  a very simple producer. It consumes the metrics stream and produces output on stdout in JSON format.
 type SimpleProducer struct {
-    metricsChannel chan Metric
+	metricsChannel chan Metric
+	producerState
 }
 
-func (s * SimpleProducer) In(c chan Metric){
-  s.metricsChannel = c
+func (s *SimpleProducer) Name() string {
+	return "stdout"
 }
 
-func (s * SimpleProducer) Start(){
-  go s.produce()
+func (s *SimpleProducer) Start(c chan Metric) error {
+	s.metricsChannel = c
+	s.started()
+	go s.produce()
+	return nil
 }
 
-func (s *SimpleProducer) produce() {
-  for {
-    metric := <- s.metricsChannel
-    json, err := json.MarshalIndent(metric, "", " ")
-    if err != nil {
-      return
-    }
-    fmt.Printf(string(json))
-    }
+func (s *SimpleProducer) Stop() error {
+	s.wait()
+	return nil
 }
 
+func (s *SimpleProducer) produce() {
+
+	defer s.stopped()
+
+	for metric := range s.metricsChannel {
+		json, err := json.MarshalIndent(metric, "", " ")
+		if err != nil {
+			s.dropped(1)
+			continue
+		}
+		fmt.Printf(string(json))
+		s.produced(1)
+	}
+}
@@ -2,9 +2,13 @@ package metrics
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	gologger "github.com/op/go-logging"
 	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
 )
 
 // most of this SSE implementation was taken from:
@@ -12,21 +16,31 @@ import (
 
 // The SSEBroker is responsible for keeping a list of which Clients (browsers)
 // are currently attached and broadcasting events (messages) to those Clients.
+//
+// The same broker serves the WebSocket stream, so SSE and WebSocket clients
+// get the same events, filters and buffering.
+//
+// The broker never blocks on a client. Every client has a bounded buffer and
+// when a slow client lets it fill up, its oldest events are dropped. Events
+// carry an id, and the last ReplaySize events are kept so a reconnecting
+// client sending a Last-Event-ID header gets the events it missed.
 type SSEBroker struct {
 
-	// Create a map of Clients, the keys of the map are the channels
-	// over which we can push messages to attached Clients.  (The values
-	// are just booleans and are meaningless.)
+	// The attached Clients, with the buffer and filter of each.
 	//
-	Clients map[chan Metric]bool
+	Clients map[*SSEClient]bool
 
 	// Channel into which new Clients can be pushed
 	//
-	NewClients chan chan Metric
+	NewClients chan *SSEClient
 
 	// Channel into which disconnected Clients should be pushed
 	//
-	DefunctClients chan chan Metric
+	DefunctClients chan *SSEClient
+
+	// Channel into which new filters of attached Clients are pushed
+	//
+	FilterChanges chan SSEFilterChange
 
 	// Channel into which messages are pushed to be broadcast out
 	// to attahed Clients.
@@ -35,6 +49,88 @@ type SSEBroker struct {
 
 	// the central logger
 	Log *gologger.Logger
+
+	// the number of events buffered per client
+	BufferSize int
+
+	// the number of events kept for clients that reconnect
+	ReplaySize int
+
+	// the interval of the comments that keep idle connections open
+	HeartbeatInterval time.Duration
+
+	// the number of attached Clients, readable outside of the broker routine
+	clientCount int32
+
+	// the events dropped for slow clients, readable outside of the broker routine
+	dropped int64
+
+	// the events published next to the metrics stream
+	published chan Metric
+
+	// the id of the last event and the last events, only used by the broker routine
+	lastID uint64
+	replay []SSEEvent
+}
+
+func NewSSEBroker(metricsChannel chan Metric, log *gologger.Logger) *SSEBroker {
+	return &SSEBroker{
+		Clients:           make(map[*SSEClient]bool),
+		NewClients:        make(chan *SSEClient),
+		DefunctClients:    make(chan *SSEClient),
+		FilterChanges:     make(chan SSEFilterChange),
+		MetricsChannel:    metricsChannel,
+		Log:               log,
+		BufferSize:        1000,
+		ReplaySize:        1000,
+		HeartbeatInterval: 15 * time.Second,
+		published:         make(chan Metric, 100),
+	}
+}
+
+// a metric with the id it is streamed with
+type SSEEvent struct {
+	ID     uint64
+	Metric Metric
+}
+
+// a client attached to the broker, with the filter selecting its metrics
+type SSEClient struct {
+	Events      chan SSEEvent
+	Filter      *SSEFilter
+	LastEventID uint64
+	dropped     int64
+}
+
+// a new filter for an attached client, set by the broker routine as it is the only one using filters
+type SSEFilterChange struct {
+	Client *SSEClient
+	Filter *SSEFilter
+}
+
+// Publishes an event that does not come from the metrics stream, like a change of the config, to all
+// clients. The event is dropped when the broker cannot keep up.
+func (b *SSEBroker) Publish(m Metric) {
+	select {
+	case b.published <- m:
+	default:
+		atomic.AddInt64(&b.dropped, 1)
+	}
+}
+
+// returns the number of attached Clients
+func (b *SSEBroker) ClientCount() int {
+	return int(atomic.LoadInt32(&b.clientCount))
+}
+
+// returns the number of events dropped because clients could not keep up
+func (b *SSEBroker) Dropped() int64 {
+	return atomic.LoadInt64(&b.dropped)
+}
+
+// returns the number of events dropped for this client
+func (c *SSEClient) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
 }
 
 // This SSEBroker method starts a new goroutine.  It handles
@@ -43,34 +139,90 @@ type SSEBroker struct {
 //
 func (b *SSEBroker) Start() {
 
-	counter := 0
-
 	for {
 
 		// Block until we receive from one of the
-		// three following channels.
+		// five following channels.
 		select {
 
 		case s := <-b.NewClients:
 
 			// There is a new client attached and we
-			// want to start sending them messages.
+			// want to start sending them messages,
+			// starting with the ones it missed.
 			b.Clients[s] = true
+			atomic.StoreInt32(&b.clientCount, int32(len(b.Clients)))
 			b.Log.Notice("Added new SSE stream client")
 
+			if s.LastEventID > 0 {
+				for _, event := range b.replay {
+					if event.ID > s.LastEventID {
+						b.send(s, event)
+					}
+				}
+			}
+
 		case s := <-b.DefunctClients:
 
 			// A client has dettached and we want to
 			// stop sending them messages.
 			delete(b.Clients, s)
+			atomic.StoreInt32(&b.clientCount, int32(len(b.Clients)))
 			b.Log.Notice("Removed SSE stream client")
 
+		case change := <-b.FilterChanges:
+
+			// A client subscribed to other metrics.
+			change.Client.Filter = change.Filter
+
+		case metric := <-b.published:
+			b.broadcast(metric)
+
 		case metric := <-b.MetricsChannel:
-			counter += 1
-			// b.Log.Notice("received metrics in SSEBroker: %v", counter)
-			for s, _ := range b.Clients {
-				s <- metric
-			}
+			b.broadcast(metric)
+		}
+	}
+}
+
+// Gives a metric the next id, keeps it for replays and sends it to all Clients.
+func (b *SSEBroker) broadcast(metric Metric) {
+
+	b.lastID++
+	event := SSEEvent{b.lastID, metric}
+
+	b.replay = append(b.replay, event)
+	if len(b.replay) > b.ReplaySize {
+		b.replay = b.replay[len(b.replay)-b.ReplaySize:]
+	}
+
+	for s := range b.Clients {
+		b.send(s, event)
+	}
+}
+
+// Sends an event to a client without blocking. When the buffer of the client
+// is full, its oldest event is dropped. Only the broker routine sends, so the
+// buffer has room after dropping one.
+func (b *SSEBroker) send(s *SSEClient, event SSEEvent) {
+
+	// metrics are filtered here, so clients only serialize what they asked for. Other events, like changes of
+	// the config, go to all clients.
+	if s.Filter != nil && event.Metric.Type == "router-metric" && !s.Filter.Match(event.Metric) {
+		return
+	}
+
+	for {
+		select {
+		case s.Events <- event:
+			return
+		default:
+		}
+
+		select {
+		case <-s.Events:
+			atomic.AddInt64(&s.dropped, 1)
+			atomic.AddInt64(&b.dropped, 1)
+		default:
 		}
 	}
 }
@@ -87,39 +239,83 @@ func (b *SSEBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create a new channel, over which the SSEBroker can
-	// send this client messages.
-	messageChan := make(chan Metric)
+	client, err := b.NewClient(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Add this client to the map of those that should
 	// receive updates
-	b.NewClients <- messageChan
-
-	// Listen to the closing of the http connection via the CloseNotifier
-	notify := w.(http.CloseNotifier).CloseNotify()
-	go func() {
-		<-notify
-		// Remove this client from the map of attached Clients
-		// when `EventHandler` exits.
-		b.DefunctClients <- messageChan
-		b.Log.Warning("HTTP connection for SSE stream just closed.")
+	b.NewClients <- client
+
+	// Remove this client from the map of attached Clients
+	// when the connection closes or writing to it fails.
+	defer func() {
+		b.DefunctClients <- client
+		if dropped := client.Dropped(); dropped > 0 {
+			b.Log.Warning("HTTP connection for SSE stream just closed, dropped %d events for it.", dropped)
+		} else {
+			b.Log.Warning("HTTP connection for SSE stream just closed.")
+		}
 	}()
 
+	// Send the headers right away, so the browser knows the stream is open
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	f.Flush()
+
+	heartbeat := time.NewTicker(b.HeartbeatInterval)
+	defer heartbeat.Stop()
+
 	for {
+		select {
+
+		case <-r.Context().Done():
+			return
 
-		msg, open := <-messageChan
+		case event := <-client.Events:
+			json, err := json.Marshal(event.Metric)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Metric.Type, json); err != nil {
+				return
+			}
+			f.Flush()
 
-		if !open {
-			break
+		case <-heartbeat.C:
+			// a comment line, ignored by browsers, but it keeps proxies from closing an idle connection
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			f.Flush()
 		}
+	}
+}
 
-		json, err := json.Marshal(msg)
-		if err != nil {
-			return
+// Creates a client for a stream request. The query parameters select the metrics the client receives, and
+// a reconnecting client sends the id of the last event it received in the Last-Event-ID header.
+func (b *SSEBroker) NewClient(r *http.Request) (*SSEClient, error) {
+
+	filter, err := ParseSSEFilter(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	var lastEventID uint64
+	if header := r.Header.Get("Last-Event-ID"); len(header) > 0 {
+		if lastEventID, err = strconv.ParseUint(header, 10, 64); err != nil {
+			return nil, errors.New("invalid Last-Event-ID: " + header)
 		}
-		fmt.Fprintf(w, "event: router-metric\ndata: %s\n\n", json)
-		f.Flush()
 	}
-	// Done.
-	b.Log.Notice("Finished HTTP stream request at ", r.URL.Path)
+
+	// Create a new buffered channel, over which the SSEBroker can
+	// send this client messages.
+	return &SSEClient{
+		Events:      make(chan SSEEvent, b.BufferSize),
+		Filter:      filter,
+		LastEventID: lastEventID,
+	}, nil
 }
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSSEBroker() (*SSEBroker, chan Metric) {
+
+	metrics := make(chan Metric)
+	broker := NewSSEBroker(metrics, testLog)
+	go broker.Start()
+	return broker, metrics
+}
+
+func testMetric(value float64) Metric {
+	return Metric{[]string{"routes:r", "route", "metrics:scur"}, value, "2015-02-24T18:45:07Z", "router-metric"}
+}
+
+// reads lines from the stream until one starts with the prefix, or fails the test after a few seconds
+func readStreamLine(t *testing.T, lines chan string, prefix string) string {
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case line, open := <-lines:
+			if !open {
+				t.Fatalf("Stream closed before reading a line starting with %q", prefix)
+			}
+			if strings.HasPrefix(line, prefix) {
+				return line
+			}
+		case <-timeout:
+			t.Fatalf("Timed out reading a line starting with %q", prefix)
+		}
+	}
+}
+
+// opens the stream and returns its lines
+func openStream(t *testing.T, ctx context.Context, url string, lastEventID string) chan string {
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req = req.WithContext(ctx)
+	if len(lastEventID) > 0 {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer resp.Body.Close()
+		defer close(lines)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
+
+func waitForClients(t *testing.T, broker *SSEBroker, count int) {
+
+	for i := 0; i < 200; i++ {
+		if broker.ClientCount() == count {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d clients, got %d", count, broker.ClientCount())
+}
+
+func TestSSEBroker_SlowClient(t *testing.T) {
+
+	broker, metrics := newTestSSEBroker()
+
+	// a client that never reads
+	client := &SSEClient{Events: make(chan SSEEvent, 2)}
+	broker.NewClients <- client
+
+	for i := 1; i <= 5; i++ {
+		select {
+		case metrics <- testMetric(float64(i)):
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected a slow client not to block the broker")
+		}
+	}
+
+	// the broker handled the last metric once it takes the next message
+	broker.DefunctClients <- client
+
+	if client.Dropped() != 3 || broker.Dropped() != 3 {
+		t.Errorf("Expected 3 dropped events, got %d", client.Dropped())
+	}
+	if event := <-client.Events; event.ID != 4 || event.Metric.Value != 4 {
+		t.Errorf("Expected the oldest events to be dropped, got event %d", event.ID)
+	}
+}
+
+func TestSSEBroker_StreamAndDisconnect(t *testing.T) {
+
+	broker, metrics := newTestSSEBroker()
+	broker.HeartbeatInterval = 10 * time.Millisecond
+
+	server := httptest.NewServer(broker)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lines := openStream(t, ctx, server.URL+"?metric=scur", "")
+	waitForClients(t, broker, 1)
+
+	readStreamLine(t, lines, ": heartbeat")
+
+	metrics <- testMetric(3)
+	if line := readStreamLine(t, lines, "id: "); line != "id: 1" {
+		t.Errorf("Expected the first event to have id 1, got %s", line)
+	}
+	readStreamLine(t, lines, "event: router-metric")
+	if line := readStreamLine(t, lines, "data: "); !strings.Contains(line, `"value":3`) {
+		t.Errorf("Expected the metric as data, got %s", line)
+	}
+
+	// closing the connection removes the client from the broker
+	cancel()
+	waitForClients(t, broker, 0)
+}
+
+func TestSSEBroker_LastEventID(t *testing.T) {
+
+	broker, metrics := newTestSSEBroker()
+
+	server := httptest.NewServer(broker)
+	defer server.Close()
+
+	for i := 1; i <= 3; i++ {
+		metrics <- testMetric(float64(i))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the client saw the first event before reconnecting, so it gets the other two
+	lines := openStream(t, ctx, server.URL, "1")
+
+	if line := readStreamLine(t, lines, "id: "); line != "id: 2" {
+		t.Errorf("Expected the replay to start after the last event id, got %s", line)
+	}
+	if line := readStreamLine(t, lines, "id: "); line != "id: 3" {
+		t.Errorf("Expected the replay to continue with id 3, got %s", line)
+	}
+}
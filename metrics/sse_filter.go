@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"errors"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+  The SSEFilter selects the metrics an SSE client receives, set by the query parameters of the stream:
+
+      route, service, server, metric   only metrics with one of the given names, i.e. ?route=r1,r2
+      type                             only metrics of the given kind of proxy: route, service, server, mirror or socket
+      sample                           the fraction of the metrics to send, i.e. ?sample=0.1 sends 1 in 10
+      interval                         send a metric of the same proxy at most once per interval, i.e. ?interval=10s
+
+  Parameters can be repeated or hold comma separated values. A filter is only used by the broker routine,
+  so its throttling state needs no locking.
+*/
+type SSEFilter struct {
+	Routes   map[string]bool
+	Services map[string]bool
+	Servers  map[string]bool
+	Metrics  map[string]bool
+	Types    map[string]bool
+	Sample   float64
+	Interval time.Duration
+
+	lastSent map[string]time.Time
+}
+
+var sseFilterTypes = map[string]bool{"route": true, "service": true, "server": true, "mirror": true, "socket": true}
+
+// parses a filter from the query parameters of a stream request
+func ParseSSEFilter(query url.Values) (*SSEFilter, error) {
+
+	f := &SSEFilter{
+		Routes:   filterValues(query, "route"),
+		Services: filterValues(query, "service"),
+		Servers:  filterValues(query, "server"),
+		Metrics:  filterValues(query, "metric"),
+		Types:    filterValues(query, "type"),
+		Sample:   1,
+		lastSent: make(map[string]time.Time),
+	}
+
+	for t := range f.Types {
+		if !sseFilterTypes[t] {
+			return nil, errors.New("invalid type: " + t)
+		}
+	}
+
+	if sample := query.Get("sample"); len(sample) > 0 {
+		rate, err := strconv.ParseFloat(sample, 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return nil, errors.New("invalid sample, should be between 0 and 1: " + sample)
+		}
+		f.Sample = rate
+	}
+
+	if interval := query.Get("interval"); len(interval) > 0 {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d < 0 {
+			return nil, errors.New("invalid interval: " + interval)
+		}
+		f.Interval = d
+	}
+
+	return f, nil
+}
+
+// gets the set of values of a query parameter, nil when it is not set
+func filterValues(query url.Values, key string) map[string]bool {
+
+	var values map[string]bool
+	for _, param := range query[key] {
+		for _, value := range strings.Split(param, ",") {
+			if value = strings.TrimSpace(value); len(value) > 0 {
+				if values == nil {
+					values = make(map[string]bool)
+				}
+				values[value] = true
+			}
+		}
+	}
+	return values
+}
+
+// checks if a metric passes the filter, and if so records it for throttling
+func (f *SSEFilter) Match(m Metric) bool {
+
+	var route, service, server, metric, kind string
+	for _, tag := range m.Tags {
+		kv := strings.SplitN(tag, ":", 2)
+		switch {
+		case len(kv) == 1:
+			if sseFilterTypes[tag] {
+				kind = tag
+			}
+		case kv[0] == "routes":
+			route = kv[1]
+		case kv[0] == "services":
+			service = kv[1]
+		case kv[0] == "servers":
+			server = kv[1]
+		case kv[0] == "metrics":
+			metric = kv[1]
+		}
+	}
+
+	if !filterMatch(f.Routes, route) || !filterMatch(f.Services, service) || !filterMatch(f.Servers, server) ||
+		!filterMatch(f.Metrics, metric) || !filterMatch(f.Types, kind) {
+		return false
+	}
+
+	if f.Sample < 1 && rand.Float64() >= f.Sample {
+		return false
+	}
+
+	if f.Interval > 0 {
+		key := strings.Join(m.Tags, ",")
+		now := time.Now()
+		if last, ok := f.lastSent[key]; ok && now.Sub(last) < f.Interval {
+			return false
+		}
+		f.lastSent[key] = now
+	}
+
+	return true
+}
+
+func filterMatch(values map[string]bool, value string) bool {
+	return values == nil || values[value]
+}
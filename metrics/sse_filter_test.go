@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSSEFilter_Match(t *testing.T) {
+
+	query, _ := url.ParseQuery("route=r1,r2&type=server&metric=scur&metric=rate")
+	filter, err := ParseSSEFilter(query)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tests := []struct {
+		tags     []string
+		expected bool
+	}{
+		{[]string{"routes:r1", "services:s", "servers:x", "server", "metrics:scur"}, true},
+		{[]string{"routes:r2", "services:s", "servers:x", "server", "metrics:rate"}, true},
+		{[]string{"routes:r3", "services:s", "servers:x", "server", "metrics:scur"}, false},
+		{[]string{"routes:r1", "services:s", "service", "metrics:scur"}, false},
+		{[]string{"routes:r1", "services:s", "servers:x", "server", "metrics:qcur"}, false},
+		{[]string{"router", "haproxy", "metrics:restarts"}, false},
+	}
+
+	for _, test := range tests {
+		if match := filter.Match(Metric{test.tags, 1, "", "router-metric"}); match != test.expected {
+			t.Errorf("Expected match of %v to be %t", test.tags, test.expected)
+		}
+	}
+}
+
+func TestSSEFilter_Interval(t *testing.T) {
+
+	query, _ := url.ParseQuery("interval=1h")
+	filter, err := ParseSSEFilter(query)
+	if err != nil || filter.Interval != time.Hour {
+		t.Fatalf("Expected an interval of an hour")
+	}
+
+	scur := Metric{[]string{"routes:r", "route", "metrics:scur"}, 1, "", "router-metric"}
+	rate := Metric{[]string{"routes:r", "route", "metrics:rate"}, 1, "", "router-metric"}
+
+	if !filter.Match(scur) || !filter.Match(rate) {
+		t.Errorf("Expected the first metric of every proxy to pass")
+	}
+	if filter.Match(scur) {
+		t.Errorf("Expected a metric to be throttled within the interval")
+	}
+}
+
+func TestSSEFilter_Invalid(t *testing.T) {
+
+	for _, q := range []string{"type=frontend", "sample=0", "sample=2", "interval=often"} {
+		query, _ := url.ParseQuery(q)
+		if _, err := ParseSSEFilter(query); err == nil {
+			t.Errorf("Expected %s to be refused", q)
+		}
+	}
+}
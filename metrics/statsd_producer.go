@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"errors"
+	gologger "github.com/op/go-logging"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+  The StatsdProducer sends the metrics stream to StatsD over UDP as gauges. Metrics are named by the dotted
+  path of their tags, or with DogStatsD tags by their name only, with the tags sent along:
+
+      vamp.routes.r.services.s.scur:3|g
+      vamp.scur:3|g|#routes:r,services:s,service
+
+  Lines are collected in batches of BatchSize, or for the FlushInterval, and sent in packets of at most
+  MaxPacketSize bytes.
+*/
+type StatsdProducer struct {
+	Host          string
+	Port          int
+	Prefix        string
+	DogStatsd     bool
+	BatchSize     int
+	MaxPacketSize int
+	FlushInterval time.Duration
+	Log           *gologger.Logger
+
+	metricsChannel chan Metric
+	conn           *reconnectingConn
+	producerState
+}
+
+func NewStatsdProducer(host string, port int, prefix string, log *gologger.Logger) *StatsdProducer {
+	return &StatsdProducer{
+		Host:          host,
+		Port:          port,
+		Prefix:        prefix,
+		BatchSize:     100,
+		MaxPacketSize: 1432,
+		FlushInterval: time.Second,
+		Log:           log,
+	}
+}
+
+func (s *StatsdProducer) Name() string {
+	return "statsd"
+}
+
+func (s *StatsdProducer) Start(c chan Metric) error {
+
+	if len(s.Host) == 0 {
+		return errors.New("no StatsD host configured")
+	}
+
+	connection := s.Host + ":" + strconv.Itoa(s.Port)
+	s.Log.Notice("Sending metrics to StatsD on " + connection)
+
+	s.metricsChannel = c
+	s.conn = newReconnectingConn("udp", connection)
+	s.started()
+
+	go func() {
+		defer s.stopped()
+		batchLines(s.metricsChannel, s.format, s.BatchSize, s.FlushInterval, s.flush)
+	}()
+	return nil
+}
+
+func (s *StatsdProducer) Stop() error {
+	s.wait()
+	return s.conn.Close()
+}
+
+func (s *StatsdProducer) format(metric Metric) string {
+
+	if !s.DogStatsd {
+		return MetricPath(s.Prefix, metric.Tags) + ":" + formatValue(metric.Value) + "|g"
+	}
+
+	tags := []string{}
+	for _, tag := range metric.Tags {
+		if !strings.HasPrefix(tag, "metrics:") {
+			tags = append(tags, strings.Replace(tag, ",", "_", -1))
+		}
+	}
+
+	line := MetricPath(s.Prefix, []string{"metrics:" + metricName(metric.Tags)}) + ":" + formatValue(metric.Value) + "|g"
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// sends the lines in as few packets as possible without exceeding MaxPacketSize
+func (s *StatsdProducer) flush(lines []string) {
+
+	packet := ""
+	for _, line := range lines {
+		if len(packet) > 0 && len(packet)+1+len(line) > s.MaxPacketSize {
+			s.send(packet)
+			packet = ""
+		}
+		if len(packet) > 0 {
+			packet += "\n"
+		}
+		packet += line
+	}
+	if len(packet) > 0 {
+		s.send(packet)
+	}
+}
+
+// UDP packets that cannot be sent are lost, StatsD aggregates over the next ones anyway
+func (s *StatsdProducer) send(packet string) {
+
+	lines := strings.Count(packet, "\n") + 1
+	if _, err := s.conn.Write([]byte(packet)); err != nil {
+		if err != errBackoff {
+			s.Log.Error("Error sending metrics to StatsD: " + err.Error())
+			s.failed(err, false)
+		}
+		s.dropped(lines)
+		return
+	}
+	s.produced(lines)
+}
@@ -5,15 +5,22 @@ import (
 	gologger "github.com/op/go-logging"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Streamer struct {
-	wantedMetrics []string
-	haRuntime     *haproxy.Runtime
-	pollFrequency int
-	Clients       map[chan Metric]bool
-	Log           *gologger.Logger
+	haRuntime haproxy.RuntimeProvider
+	Clients   map[chan Metric]bool
+	Log       *gologger.Logger
+
+	// resolves the slots of services to the servers filling them, without it slots are tagged by their name
+	HaConfig *haproxy.Config
+
+	// the config is read by the polling and parsing routines and set by the API
+	config  StreamerConfig
+	mutex   sync.RWMutex
+	changed chan bool
 }
 
 // Adds a client to which messages can be multiplexed.
@@ -21,17 +28,46 @@ func (s *Streamer) AddClient(c chan Metric) {
 	s.Clients[c] = true
 }
 
-// Just sets the metrics we want for now...
-func NewStreamer(haRuntime *haproxy.Runtime, frequency int, log *gologger.Logger) *Streamer {
+// Creates a streamer with the default config, polling at the given frequency in milliseconds.
+func NewStreamer(haRuntime haproxy.RuntimeProvider, frequency int, log *gologger.Logger) *Streamer {
+
+	config := DefaultStreamerConfig()
+	config.PollInterval = frequency
+
 	return &Streamer{
-		Log:           log,
-		wantedMetrics: []string{"scur", "qcur", "qmax", "smax", "slim", "ereq", "econ", "lastsess", "qtime", "ctime", "rtime", "ttime", "req_rate", "req_rate_max", "req_tot", "rate", "rate_lim", "rate_max", "hrsp_1xx", "hrsp_2xx", "hrsp_3xx", "hrsp_4xx", "hrsp_5xx"},
-		haRuntime:     haRuntime,
-		pollFrequency: frequency,
-		Clients:       make(map[chan Metric]bool),
+		Log:       log,
+		haRuntime: haRuntime,
+		Clients:   make(map[chan Metric]bool),
+		config:    config,
+		changed:   make(chan bool, 1),
 	}
 }
 
+// gets the current config
+func (s *Streamer) Config() StreamerConfig {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.config.copy()
+}
+
+// Sets the config, it is used from the next poll on. A new poll interval also ends the current wait.
+func (s *Streamer) SetConfig(config StreamerConfig) error {
+
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	s.config = config.copy()
+	s.mutex.Unlock()
+
+	select {
+	case s.changed <- true:
+	default:
+	}
+	return nil
+}
+
 // simple wrapper for the actual start command.
 func (s *Streamer) Start() {
 
@@ -58,7 +94,7 @@ func (s *Streamer) StartProtected() {
 
 	statsChannel := make(chan map[string]map[string]string, 1000)
 
-	go ParseMetrics(statsChannel, s.Clients, s.wantedMetrics)
+	go ParseMetricsWithConfig(statsChannel, s.Clients, s.Config, s.slotServers)
 
 	for {
 		// start pumping the stats into the channel
@@ -67,77 +103,112 @@ func (s *Streamer) StartProtected() {
 			s.Log.Error(err.Error())
 		}
 		statsChannel <- stats
-		time.Sleep(time.Duration(s.pollFrequency) * time.Millisecond)
+
+		select {
+		case <-time.After(time.Duration(s.Config().PollInterval) * time.Millisecond):
+		case <-s.changed:
+		}
+	}
+}
+
+// gets the servers filling the slots of the current config
+func (s *Streamer) slotServers() map[string]string {
+
+	if s.HaConfig == nil {
+		return nil
 	}
+	s.HaConfig.BeginReadTrans()
+	defer s.HaConfig.EndReadTrans()
+	return s.HaConfig.SlotServers()
 }
 
 /*
-	Parses a []Stats and injects it into each Metric channel in a map of channels
+	Parses a []Stats and injects it into each Metric channel in a map of channels, using the default config
+	with the given wanted metrics.
 */
-
 func ParseMetrics(statsChannel chan map[string]map[string]string, clients map[chan Metric]bool, wantedMetrics []string) {
 
-	wantedFrontendMetric := make(map[string]bool)
-	wantedFrontendMetric["ereq"] = true
-	wantedFrontendMetric["rate_lim"] = true
-	wantedFrontendMetric["req_rate_max"] = true
-	wantedFrontendMetric["req_rate"] = true
+	config := DefaultStreamerConfig()
+	config.Metrics = wantedMetrics
 
-	for {
-		select {
-		case stats := <-statsChannel:
-			localTime := time.Now().Format(time.RFC3339)
+	// frontends only emitted the frontend metrics that were also wanted
+	wanted := make(map[string]bool)
+	for _, metric := range wantedMetrics {
+		wanted[metric] = true
+	}
+	frontendMetrics := []string{}
+	for _, metric := range config.FrontendMetrics {
+		if wanted[metric] {
+			frontendMetrics = append(frontendMetrics, metric)
+		}
+	}
+	config.FrontendMetrics = frontendMetrics
 
-			// for each proxy in the stats dump, pick out the wanted metrics.
-			for _, proxy := range stats {
+	ParseMetricsWithConfig(statsChannel, clients, func() StreamerConfig { return config }, nil)
+}
 
-				// loop over all wanted metrics for the current proxy
-				for _, metric := range wantedMetrics {
+/*
+	Parses a []Stats and injects it into each Metric channel in a map of channels. The config is read for
+	every []Stats, so changes apply from the next poll on. Next to the wanted metrics, the rates and ratios
+	derived from the counters of every proxy are emitted, tagged "derived". The slots are read for every
+	[]Stats as well, a nil slots func tags slots by their own name.
+*/
+func ParseMetricsWithConfig(statsChannel chan map[string]map[string]string, clients map[chan Metric]bool, config func() StreamerConfig, slots func() map[string]string) {
 
-					// discard all empty metrics
-					if proxy[metric] != "" {
+	// the frontend and backend of a route have the same tags, so each derives what the other one does not
+	frontendDerivedMetric := make(map[string]bool)
+	frontendDerivedMetric["req_tot_rate"] = true
+	frontendDerivedMetric["ereq_rate"] = true
 
-						value := proxy[metric]
-						svname := proxy["svname"]
-						tags := []string{}
-						pxnames := strings.Split(proxy["pxname"], "::")
+	deriver := NewDeriver()
 
-						// allow only some FRONTEND metrics and all non-FRONTEND metrics
-						if (svname == "FRONTEND" && wantedFrontendMetric[metric]) || svname != "FRONTEND" {
+	for {
+		select {
+		case stats := <-statsChannel:
+			now := time.Now()
+			localTime := now.Format(time.RFC3339)
 
-							// Compile tags
-							// we tag the metrics according to the following scheme
-							switch {
+			current := config()
 
-							//- if pxname has no "." separator, and svname is [BACKEND|FRONTEND] it is the top route or "endpoint"
-							case len(pxnames) == 1 && (svname == "BACKEND" || svname == "FRONTEND"):
-								tags = append(tags, "routes:"+proxy["pxname"], "route")
+			var slotServers map[string]string
+			if slots != nil {
+				slotServers = slots()
+			}
 
-								EmitMetric(localTime, tags, metric, value, clients)
+			// for each proxy in the stats dump, pick out the wanted metrics.
+			for key, proxy := range stats {
 
-							//-if pxname has no "."  separator, and svname is not [BACKEND|FRONTEND] it is an "in between"
-							// server that routes to the actual service via a socket.
-							case len(pxnames) == 1 && (svname != "BACKEND" || svname != "FRONTEND"):
-							// sockName := strings.Split(svname, ".")
-							// tags = append(tags, "routes:"+proxy["pxname"], "socket_servers:"+sockName[1])
+				svname := proxy["svname"]
+				pxnames := strings.Split(proxy["pxname"], "::")
+				isMirror := len(pxnames) == 3 && pxnames[2] == "mirror"
 
-							// we dont emit this metrics currently
-							// EmitMetric(localTime, tags, metric, value, counter, c)
+				// allow only the FRONTEND metrics, except for mirrors, and all non-FRONTEND metrics
+				wantedMetrics := current.Metrics
+				if svname == "FRONTEND" && !isMirror {
+					wantedMetrics = current.FrontendMetrics
+				}
 
-							//- if pxname has a separator, and svname is [BACKEND|FRONTEND] it is a service
-							case len(pxnames) > 1 && (svname == "BACKEND" || svname == "FRONTEND"):
-								tags = append(tags, "routes:"+pxnames[0], "services:"+pxnames[1], "service")
-								EmitMetric(localTime, tags, metric, value, clients)
+				// loop over all wanted metrics for the current proxy
+				for _, metric := range wantedMetrics {
 
-							//- if svname is not [BACKEND|FRONTEND] its a SERVER in a SERVICE and we prepend it with "server:"
-							case len(pxnames) > 1 && (svname != "BACKEND" && svname != "FRONTEND"):
-								tags = append(tags, "routes:"+pxnames[0], "services:"+pxnames[1], "servers:"+svname, "server")
-								EmitMetric(localTime, tags, metric, value, clients)
-							}
+					// discard all empty metrics
+					if proxy[metric] != "" {
+						if tags := streamedProxyTags(proxy["pxname"], svname, slotServers, current.SocketServers); tags != nil {
+							EmitMetric(localTime, tags, metric, proxy[metric], clients)
 						}
 					}
 				}
+
+				for _, derived := range deriver.Derive(key, proxy, now) {
+					if (svname == "FRONTEND" && !isMirror && !frontendDerivedMetric[derived.Name]) || (svname == "BACKEND" && frontendDerivedMetric[derived.Name]) {
+						continue
+					}
+					if tags := streamedProxyTags(proxy["pxname"], svname, slotServers, current.SocketServers); tags != nil {
+						EmitMetric(localTime, append(tags, "derived"), derived.Name, formatValue(derived.Value), clients)
+					}
+				}
 			}
+			deriver.Forget(stats)
 		}
 	}
 }
@@ -145,7 +216,7 @@ func ParseMetrics(statsChannel chan map[string]map[string]string, clients map[ch
 func EmitMetric(time string, tags []string, metric string, value string, clients map[chan Metric]bool) {
 	tags = append(tags, "metrics:"+metric)
 	_type := "router-metric"
-	metricValue, _ := strconv.Atoi(value)
+	metricValue, _ := strconv.ParseFloat(value, 64)
 
 	//debug
 	// fmt.Println("%v => metric %v m: %v\n", time, tags[0], metricValue)
@@ -153,3 +224,73 @@ func EmitMetric(time string, tags []string, metric string, value string, clients
 		s <- Metric{tags, metricValue, time, _type}
 	}
 }
+
+// the event published on the streams after a change of the config, with the new revision as value
+func ConfigEvent(revision int) Metric {
+	return Metric{[]string{"router", "config", "metrics:revision"}, float64(revision), time.Now().Format(time.RFC3339), "router-config"}
+}
+
+/*
+  Tags a proxy from the stats by the route, service and server it belongs to, according to the following
+  scheme. Slots, keyed as "<pxname>:<svname>", are tagged by the server filling them. Returns nil for
+  proxies whose metrics are not emitted, like free slots.
+*/
+func ProxyTags(pxname string, svname string, slots map[string]string) []string {
+
+	pxnames := strings.Split(pxname, "::")
+	isProxy := svname == "BACKEND" || svname == "FRONTEND"
+
+	if server, ok := slots[pxname+":"+svname]; ok {
+		if len(server) == 0 {
+			return nil
+		}
+		svname = server
+	}
+
+	switch {
+
+	//- if pxname has a "mirror" postfix, it is the frontend receiving the mirrored requests
+	// for a service.
+	case len(pxnames) == 3 && pxnames[2] == "mirror":
+		return []string{"routes:" + pxnames[0], "services:" + pxnames[1], "mirror"}
+
+	//- if pxname has no "::" separator, and svname is [BACKEND|FRONTEND] it is the top route or "endpoint"
+	case len(pxnames) == 1 && isProxy:
+		return []string{"routes:" + pxname, "route"}
+
+	//-if pxname has no "::"  separator, and svname is not [BACKEND|FRONTEND] it is an "in between"
+	// server that routes to the actual service via a socket. We dont emit this metrics currently.
+	case len(pxnames) == 1:
+		return nil
+
+	//- if pxname has a separator, and svname is [BACKEND|FRONTEND] it is a service
+	case isProxy:
+		return []string{"routes:" + pxnames[0], "services:" + pxnames[1], "service"}
+
+	//- if svname is not [BACKEND|FRONTEND] its a SERVER in a SERVICE and we prepend it with "server:"
+	default:
+		return []string{"routes:" + pxnames[0], "services:" + pxnames[1], "servers:" + svname, "server"}
+	}
+}
+
+/*
+  Tags an "in between" server of a route backend, that routes to a service over its socket, as
+  "routes:<route>", "services:<service>" and "socket". Returns nil for all other proxies.
+*/
+func SocketServerTags(pxname string, svname string) []string {
+
+	if strings.Contains(pxname, "::") || svname == "BACKEND" || svname == "FRONTEND" {
+		return nil
+	}
+	svnames := strings.Split(svname, "::")
+	return []string{"routes:" + pxname, "services:" + svnames[len(svnames)-1], "socket"}
+}
+
+// tags a proxy for the stream, with the socket servers only when they are wanted
+func streamedProxyTags(pxname string, svname string, slots map[string]string, socketServers bool) []string {
+
+	if tags := ProxyTags(pxname, svname, slots); tags != nil || !socketServers {
+		return tags
+	}
+	return SocketServerTags(pxname, svname)
+}
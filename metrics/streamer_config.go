@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+)
+
+/*
+  The StreamerConfig selects what the streamer polls and emits, and can be changed while it runs:
+
+      metrics           the columns of "show stat" emitted for backends, servers and mirrors
+      frontendMetrics   the columns emitted for frontends. The frontend and backend of a route have the same
+                        tags, so only the columns a backend does not have are useful here.
+      pollInterval      the milliseconds between two polls of the stats
+      socketServers     also emit the metrics of the "in between" servers of a route backend, that route to a
+                        service over its socket, tagged "socket"
+*/
+type StreamerConfig struct {
+	Metrics         []string `json:"metrics"`
+	FrontendMetrics []string `json:"frontendMetrics"`
+	PollInterval    int      `json:"pollInterval"`
+	SocketServers   bool     `json:"socketServers"`
+}
+
+// polling more often than this puts too much load on the stats socket
+const MinPollInterval = 100
+
+func DefaultStreamerConfig() StreamerConfig {
+	return StreamerConfig{
+		Metrics:         []string{"scur", "qcur", "qmax", "smax", "slim", "ereq", "econ", "lastsess", "qtime", "ctime", "rtime", "ttime", "req_rate", "req_rate_max", "req_tot", "rate", "rate_lim", "rate_max", "hrsp_1xx", "hrsp_2xx", "hrsp_3xx", "hrsp_4xx", "hrsp_5xx"},
+		FrontendMetrics: []string{"ereq", "rate_lim", "req_rate_max", "req_rate"},
+		PollInterval:    3000,
+	}
+}
+
+// checks the config, the metric names end up in the tags, so they cannot be empty or hold separators
+func (c StreamerConfig) Validate() error {
+
+	if c.PollInterval < MinPollInterval {
+		return errors.New("pollInterval should be at least 100 milliseconds")
+	}
+
+	for _, metrics := range [][]string{c.Metrics, c.FrontendMetrics} {
+		for _, metric := range metrics {
+			if len(metric) == 0 || strings.ContainsAny(metric, ":, ") {
+				return errors.New("invalid metric: \"" + metric + "\"")
+			}
+		}
+	}
+	return nil
+}
+
+// copies the config, so the lists are not shared with whoever set or asked for it
+func (c StreamerConfig) copy() StreamerConfig {
+	c.Metrics = append([]string{}, c.Metrics...)
+	c.FrontendMetrics = append([]string{}, c.FrontendMetrics...)
+	return c
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"testing"
+)
+
+func TestStreamer_SetConfig(t *testing.T) {
+
+	streamer := NewStreamer(nil, 3000, testLog)
+
+	if config := streamer.Config(); config.PollInterval != 3000 || len(config.Metrics) == 0 || len(config.FrontendMetrics) != 4 {
+		t.Errorf("Expected the default config, got %+v", config)
+	}
+
+	invalid := []StreamerConfig{
+		{Metrics: []string{"scur"}, PollInterval: 10},
+		{Metrics: []string{""}, PollInterval: 1000},
+		{Metrics: []string{"scur"}, FrontendMetrics: []string{"metrics:scur"}, PollInterval: 1000},
+	}
+	for _, config := range invalid {
+		if err := streamer.SetConfig(config); err == nil {
+			t.Errorf("Expected config %+v to be refused", config)
+		}
+	}
+
+	config := StreamerConfig{Metrics: []string{"scur"}, FrontendMetrics: []string{}, PollInterval: 500, SocketServers: true}
+	if err := streamer.SetConfig(config); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// the streamer keeps its own copy
+	config.Metrics[0] = "qcur"
+	if current := streamer.Config(); current.Metrics[0] != "scur" || current.PollInterval != 500 || !current.SocketServers {
+		t.Errorf("Expected the new config, got %+v", current)
+	}
+
+	select {
+	case <-streamer.changed:
+	default:
+		t.Errorf("Expected a new config to end the wait for the next poll")
+	}
+}
+
+func TestMetrics_ParseMetricsWithConfig(t *testing.T) {
+
+	m := make(map[chan Metric]bool)
+	c := make(chan Metric, 100)
+	m[c] = true
+
+	config := StreamerConfig{Metrics: []string{"scur"}, FrontendMetrics: []string{"req_rate"}, PollInterval: 1000, SocketServers: true}
+
+	statsChannel := make(chan map[string]map[string]string)
+	go ParseMetricsWithConfig(statsChannel, m, func() StreamerConfig { return config }, nil)
+
+	statsChannel <- map[string]map[string]string{
+		"test_route_2:FRONTEND":                {"pxname": "test_route_2", "svname": "FRONTEND", "scur": "1", "req_rate": "2"},
+		"test_route_2:test_route_2::service_a": {"pxname": "test_route_2", "svname": "test_route_2::service_a", "scur": "3", "req_rate": "4"},
+	}
+
+	metrics := make(map[string]Metric)
+	for i := 0; i < 2; i++ {
+		metric := <-c
+		metrics[metric.Tags[len(metric.Tags)-1]] = metric
+	}
+
+	if metric := metrics["metrics:req_rate"]; metric.Value != 2 || metric.Tags[1] != "route" {
+		t.Errorf("Expected the configured frontend metric, got %v", metric)
+	}
+	if metric := metrics["metrics:scur"]; metric.Value != 3 || metric.Tags[0] != "routes:test_route_2" || metric.Tags[1] != "services:service_a" || metric.Tags[2] != "socket" {
+		t.Errorf("Expected the metric of the socket server, got %v", metric)
+	}
+
+	select {
+	case metric := <-c:
+		t.Errorf("Expected no other metrics, got %v", metric)
+	default:
+	}
+}
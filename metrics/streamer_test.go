@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"testing"
+	"time"
 )
 
 const (
@@ -41,7 +42,7 @@ func TestMetrics_ParseMetrics(t *testing.T) {
 	for s, _ := range m {
 		metric := <-s
 		if metric.Value != 0 {
-			t.Errorf("value was %d", metric.Value)
+			t.Errorf("value was %v", metric.Value)
 		}
 	}
 }
@@ -82,3 +83,82 @@ func getMapofMetrics() map[string]map[string]string {
 	return mapOfMaps
 
 }
+
+func TestMetrics_ParseMirrorMetrics(t *testing.T) {
+
+	m := make(map[chan Metric]bool)
+	c := make(chan Metric)
+	m[c] = true
+
+	testdata := map[string]map[string]string{
+		"test_route_2::service_b::mirror:FRONTEND": {"pxname": "test_route_2::service_b::mirror", "svname": "FRONTEND", "req_tot": "12"},
+	}
+	statsChannel := make(chan map[string]map[string]string)
+
+	go ParseMetrics(statsChannel, m, []string{"req_tot"})
+
+	statsChannel <- testdata
+
+	metric := <-c
+	if metric.Value != 12 || metric.Tags[2] != "mirror" {
+		t.Errorf("Failed to parse mirror metric: %v", metric)
+	}
+}
+
+func TestMetrics_ParseSlotMetrics(t *testing.T) {
+
+	m := make(map[chan Metric]bool)
+	c := make(chan Metric, 100)
+	m[c] = true
+
+	config := DefaultStreamerConfig()
+	config.Metrics = []string{"scur"}
+	slots := map[string]string{"test_route_2::service_a:slot_1": "server_a", "test_route_2::service_a:slot_2": ""}
+
+	statsChannel := make(chan map[string]map[string]string)
+	go ParseMetricsWithConfig(statsChannel, m, func() StreamerConfig { return config }, func() map[string]string { return slots })
+
+	statsChannel <- map[string]map[string]string{
+		"test_route_2::service_a:slot_1": {"pxname": "test_route_2::service_a", "svname": "slot_1", "scur": "3"},
+		"test_route_2::service_a:slot_2": {"pxname": "test_route_2::service_a", "svname": "slot_2", "scur": "0"},
+	}
+
+	metric := <-c
+	if metric.Value != 3 || metric.Tags[2] != "servers:server_a" {
+		t.Errorf("Failed to tag a slot by its server: %v", metric)
+	}
+
+	for {
+		select {
+		case metric := <-c:
+			if metric.Tags[2] != "servers:server_a" {
+				t.Errorf("Expected no metrics of free slots, got %v", metric)
+			}
+		case <-time.After(50 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func TestMetrics_ParseDerivedMetrics(t *testing.T) {
+
+	m := make(map[chan Metric]bool)
+	c := make(chan Metric, 100)
+	m[c] = true
+
+	statsChannel := make(chan map[string]map[string]string)
+	go ParseMetrics(statsChannel, m, []string{})
+
+	statsChannel <- map[string]map[string]string{
+		"test_route_2::service_a:server_1": {"pxname": "test_route_2::service_a", "svname": "server_1", "stot": "10"},
+	}
+	time.Sleep(10 * time.Millisecond)
+	statsChannel <- map[string]map[string]string{
+		"test_route_2::service_a:server_1": {"pxname": "test_route_2::service_a", "svname": "server_1", "stot": "20"},
+	}
+
+	metric := <-c
+	if len(metric.Tags) != 6 || metric.Tags[4] != "derived" || metric.Tags[5] != "metrics:stot_rate" || metric.Value <= 0 {
+		t.Errorf("Failed to parse derived metric: %v", metric)
+	}
+}
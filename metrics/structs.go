@@ -2,7 +2,7 @@ package metrics
 
 type Metric struct {
 	Tags      []string `json:"tags"`
-	Value     int      `json:"value"`
+	Value     float64  `json:"value"`
 	Timestamp string   `json:"timestamp"`
 	Type      string   `json:"type"`
 }
@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+  The WSStream serves the events of the SSEBroker over a WebSocket, for clients that cannot consume SSE.
+  Every event is sent as a JSON text message:
+
+      {"id":12,"event":"router-metric","data":{"tags":["routes:r","route","metrics:scur"],"value":3,...}}
+
+  The initial filter is set by the same query parameters as the SSE stream. Clients change it by sending a
+  subscription, with the parameters as keys and comma separated values, which replaces the whole filter:
+
+      {"type":"subscribe","filter":{"route":"r1,r2","metric":"scur","interval":"10s"}}
+
+  A subscription is acknowledged with a "subscribed" event, an invalid one gets an "error" event and leaves
+  the filter as it was. The server pings every HeartbeatInterval of the broker and closes connections that
+  did not answer within two intervals.
+*/
+type WSStream struct {
+	Broker   *SSEBroker
+	Upgrader websocket.Upgrader
+}
+
+func NewWSStream(broker *SSEBroker) *WSStream {
+	return &WSStream{
+		Broker: broker,
+		Upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 4096,
+			// dashboards are served from other origins, just like with the SSE stream
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+// a message sent by a client
+type WSSubscription struct {
+	Type   string            `json:"type"`
+	Filter map[string]string `json:"filter"`
+}
+
+// a message sent to a client
+type WSEvent struct {
+	ID    uint64  `json:"id,omitempty"`
+	Event string  `json:"event"`
+	Data  *Metric `json:"data,omitempty"`
+	Error string  `json:"error,omitempty"`
+}
+
+// the longest a write to a client may take
+const wsWriteTimeout = 10 * time.Second
+
+func (s *WSStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	client, err := s.Broker.NewClient(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the upgrader writes the error response itself
+	conn, err := s.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	s.Broker.NewClients <- client
+
+	defer func() {
+		s.Broker.DefunctClients <- client
+		if dropped := client.Dropped(); dropped > 0 {
+			s.Broker.Log.Warning("WebSocket connection for stream just closed, dropped %d events for it.", dropped)
+		} else {
+			s.Broker.Log.Warning("WebSocket connection for stream just closed.")
+		}
+	}()
+
+	// replies to subscriptions are handed to the writing loop, as a connection has only one writer
+	replies := make(chan WSEvent, 1)
+	closed := make(chan struct{})
+	go s.read(conn, client, replies, closed)
+
+	ping := time.NewTicker(s.Broker.HeartbeatInterval)
+	defer ping.Stop()
+
+	for {
+		var message WSEvent
+
+		select {
+		case <-closed:
+			return
+
+		case event := <-client.Events:
+			message = WSEvent{ID: event.ID, Event: event.Metric.Type, Data: &event.Metric}
+
+		case message = <-replies:
+
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+			continue
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := conn.WriteJSON(message); err != nil {
+			return
+		}
+	}
+}
+
+// reads the subscriptions of a client until the connection closes or misses its pongs
+func (s *WSStream) read(conn *websocket.Conn, client *SSEClient, replies chan WSEvent, closed chan struct{}) {
+
+	defer close(closed)
+
+	timeout := 2 * s.Broker.HeartbeatInterval
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(timeout))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+
+		reply := s.subscribe(client, data)
+		select {
+		case replies <- reply:
+		case <-time.After(wsWriteTimeout):
+			return
+		}
+	}
+}
+
+// applies a subscription message of a client and returns the reply to it
+func (s *WSStream) subscribe(client *SSEClient, data []byte) WSEvent {
+
+	var subscription WSSubscription
+	if err := json.Unmarshal(data, &subscription); err != nil {
+		return WSEvent{Event: "error", Error: "invalid message: " + err.Error()}
+	}
+	if subscription.Type != "subscribe" {
+		return WSEvent{Event: "error", Error: "unknown message type: " + subscription.Type}
+	}
+
+	query := url.Values{}
+	for key, value := range subscription.Filter {
+		query[strings.ToLower(key)] = []string{value}
+	}
+
+	filter, err := ParseSSEFilter(query)
+	if err != nil {
+		return WSEvent{Event: "error", Error: err.Error()}
+	}
+
+	s.Broker.FilterChanges <- SSEFilterChange{client, filter}
+	return WSEvent{Event: "subscribed"}
+}
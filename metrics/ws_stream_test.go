@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dialWSStream(t *testing.T, broker *SSEBroker, query string) (*websocket.Conn, func()) {
+
+	server := httptest.NewServer(NewWSStream(broker))
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+query, nil)
+	if err != nil {
+		server.Close()
+		t.Fatal(err.Error())
+	}
+	waitForClients(t, broker, 1)
+
+	return conn, func() {
+		conn.Close()
+		server.Close()
+	}
+}
+
+func readWSEvent(t *testing.T, conn *websocket.Conn) WSEvent {
+
+	var event WSEvent
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatal(err.Error())
+	}
+	return event
+}
+
+func TestWSStream_Events(t *testing.T) {
+
+	broker, metrics := newTestSSEBroker()
+	conn, closeStream := dialWSStream(t, broker, "?metric=scur")
+
+	metrics <- Metric{[]string{"routes:r", "route", "metrics:rate"}, 1, "2015-02-24T18:45:07Z", "router-metric"}
+	metrics <- testMetric(2)
+
+	event := readWSEvent(t, conn)
+	if event.ID != 2 || event.Event != "router-metric" || event.Data == nil || event.Data.Value != 2 {
+		t.Errorf("Expected only the filtered metric, got %+v", event)
+	}
+
+	broker.Publish(ConfigEvent(7))
+	if event := readWSEvent(t, conn); event.Event != "router-config" || event.Data.Value != 7 {
+		t.Errorf("Expected a config event with the revision, got %+v", event)
+	}
+
+	// closing the connection removes the client from the broker
+	closeStream()
+	waitForClients(t, broker, 0)
+}
+
+func TestWSStream_Subscribe(t *testing.T) {
+
+	broker, metrics := newTestSSEBroker()
+	conn, closeStream := dialWSStream(t, broker, "?route=other")
+	defer closeStream()
+
+	conn.WriteJSON(WSSubscription{"subscribe", map[string]string{"type": "nonsense"}})
+	if event := readWSEvent(t, conn); event.Event != "error" || len(event.Error) == 0 {
+		t.Errorf("Expected an invalid subscription to be refused, got %+v", event)
+	}
+
+	conn.WriteJSON(WSSubscription{"subscribe", map[string]string{"route": "r", "metric": "scur"}})
+	if event := readWSEvent(t, conn); event.Event != "subscribed" {
+		t.Errorf("Expected the subscription to be acknowledged, got %+v", event)
+	}
+
+	metrics <- testMetric(3)
+	if event := readWSEvent(t, conn); event.Data == nil || event.Data.Value != 3 {
+		t.Errorf("Expected a metric of the new subscription, got %+v", event)
+	}
+}
+
+func TestWSStream_Ping(t *testing.T) {
+
+	broker, _ := newTestSSEBroker()
+	broker.HeartbeatInterval = 10 * time.Millisecond
+	conn, closeStream := dialWSStream(t, broker, "")
+	defer closeStream()
+
+	pings := make(chan string, 10)
+	conn.SetPingHandler(func(data string) error {
+		pings <- data
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	// control messages are handled while reading
+	go conn.ReadMessage()
+
+	select {
+	case <-pings:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the server to ping")
+	}
+
+	// the client answers the pings, so it stays connected for longer than the pong timeout
+	time.Sleep(50 * time.Millisecond)
+	if broker.ClientCount() != 1 {
+		t.Errorf("Expected a client answering pings to stay connected")
+	}
+}